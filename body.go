@@ -0,0 +1,186 @@
+package proxy
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+)
+
+// A RequestBuffering defines how request body is buffered before proxying.
+// Buffered body is replayed to every server tried for request, so request
+// with buffered body can be passed to next upstream server.
+type RequestBuffering struct {
+    // MemoryLimit is maximum body size kept in memory. Bigger bodies are
+    // stored in temporary file. Default is 64KB.
+    MemoryLimit int64
+
+    // MaxSize is maximum size of buffered body. Bigger bodies are streamed
+    // to server and request is not passed to next server. Zero means no
+    // limit.
+    MaxSize int64
+
+    // TempDir is directory for temporary files. Default is os.TempDir().
+    TempDir string
+}
+
+// defaultMemoryLimit is default RequestBuffering.MemoryLimit.
+const defaultMemoryLimit = 64 * 1024
+
+// requestBody is request body which may be replayed to several servers.
+type requestBody struct {
+    // buf stores body kept in memory.
+    buf    []byte
+
+    // file stores body spilled to temporary file.
+    file   *os.File
+
+    // size is buffered body size.
+    size   int64
+
+    // stream is unbuffered body, it can be read only once.
+    stream io.Reader
+    closer io.Closer
+}
+
+// bufferBody reads body of request r according to buffering parameters.
+// Nil parameters disable buffering, gRPC requests are streamed. Errors of
+// reading body wrap BadRequestError or RequestEntityTooLargeError, errors of
+// temporary file wrap InternalServerError.
+func bufferBody(r *http.Request, rb *RequestBuffering) (*requestBody, error) {
+    if r.Body == nil || r.Body == http.NoBody {
+        return &requestBody{}, nil
+    }
+
+    // gRPC streams are never buffered
+    if rb == nil || isGRPC(r.Header) || (rb.MaxSize > 0 && r.ContentLength > rb.MaxSize) {
+        return &requestBody{stream: r.Body, closer: r.Body, size: r.ContentLength}, nil
+    }
+
+    limit := rb.MemoryLimit
+    if limit <= 0 {
+        limit = defaultMemoryLimit
+    }
+    if rb.MaxSize > 0 && rb.MaxSize < limit {
+        limit = rb.MaxSize
+    }
+
+    buf, err := io.ReadAll(io.LimitReader(r.Body, limit + 1))
+    if err != nil {
+        return nil, bodyReadError(err)
+    }
+    if int64(len(buf)) <= limit {
+        return &requestBody{buf: buf, size: int64(len(buf))}, nil
+    }
+
+    if rb.MaxSize > 0 && int64(len(buf)) > rb.MaxSize {
+        return &requestBody{
+            stream: io.MultiReader(bytes.NewReader(buf), r.Body),
+            closer: r.Body,
+            size: r.ContentLength,
+        }, nil
+    }
+
+    file, err := os.CreateTemp(rb.TempDir, "proxy-body-")
+    if err != nil {
+        return nil, fmt.Errorf("%w: %w", err, InternalServerError)
+    }
+    b := &requestBody{file: file}
+    n, err := file.Write(buf)
+    b.size = int64(n)
+    if err != nil {
+        b.Close()
+        return nil, fmt.Errorf("%w: %w", err, InternalServerError)
+    }
+
+    var rest io.Reader = clientReader{r.Body}
+    if rb.MaxSize > 0 {
+        rest = io.LimitReader(rest, rb.MaxSize - b.size + 1)
+    }
+    m, err := io.Copy(file, rest)
+    b.size += m
+    if err != nil {
+        b.Close()
+        if !errors.Is(err, BadRequestError) && !errors.Is(err, RequestEntityTooLargeError) {
+            // writing of temporary file failed
+            err = fmt.Errorf("%w: %w", err, InternalServerError)
+        }
+        return nil, err
+    }
+
+    if rb.MaxSize > 0 && b.size > rb.MaxSize {
+        // body is too large, stream it from file and rest from client
+        b.stream = io.MultiReader(io.NewSectionReader(file, 0, b.size), r.Body)
+        b.closer = r.Body
+        b.size = r.ContentLength
+    }
+
+    return b, nil
+}
+
+// bodyReadError wraps err of reading client request body with
+// RequestEntityTooLargeError if body exceeds http.MaxBytesReader limit, or
+// with BadRequestError.
+func bodyReadError(err error) error {
+    var maxErr *http.MaxBytesError
+    if errors.As(err, &maxErr) {
+        return fmt.Errorf("%w: %w", err, RequestEntityTooLargeError)
+    }
+    return fmt.Errorf("%w: %w", err, BadRequestError)
+}
+
+// clientReader is client request body which wraps its errors by
+// bodyReadError, so they differ from errors of writing temporary file.
+type clientReader struct {
+    io.Reader
+}
+
+// Read reads request body.
+func (r clientReader) Read(p []byte) (int, error) {
+    n, err := r.Reader.Read(p)
+    if err != nil && err != io.EOF {
+        err = bodyReadError(err)
+    }
+    return n, err
+}
+
+// replayable returns true if body can be sent to several servers.
+func (b *requestBody) replayable() bool {
+    return b.stream == nil
+}
+
+// reader returns body for next try. Unbuffered body is returned once.
+func (b *requestBody) reader() io.ReadCloser {
+    if b.stream != nil {
+        return io.NopCloser(b.stream)
+    }
+    if b.size == 0 {
+        return nil
+    }
+    if b.file != nil {
+        return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+    }
+    return io.NopCloser(bytes.NewReader(b.buf))
+}
+
+// contentLength returns body length or -1 if length is unknown.
+func (b *requestBody) contentLength() int64 {
+    if b.stream != nil && b.size <= 0 {
+        return -1
+    }
+    return b.size
+}
+
+// Close releases body and removes temporary file.
+func (b *requestBody) Close() error {
+    if b.closer != nil {
+        b.closer.Close()
+    }
+    if b.file != nil {
+        b.file.Close()
+        return os.Remove(b.file.Name())
+    }
+    return nil
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "testing/iotest"
+)
+
+func TestBufferBody(t *testing.T) {
+    content := strings.Repeat("0123456789", 10)
+
+    readAll := func (t *testing.T, b *requestBody) string {
+        r := b.reader()
+        if r == nil {
+            return ""
+        }
+        data, err := io.ReadAll(r)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return string(data)
+    }
+
+    cases := []struct{
+        name       string
+        rb         *RequestBuffering
+        replayable bool
+        file       bool
+    }{
+        {"Unbuffered", nil, false, false},
+        {"Memory", &RequestBuffering{}, true, false},
+        {"File", &RequestBuffering{MemoryLimit: 10, TempDir: t.TempDir()}, true, true},
+        {"TooLargeMemory", &RequestBuffering{MaxSize: 50}, false, false},
+        {"TooLargeFile", &RequestBuffering{MemoryLimit: 10, MaxSize: 50, TempDir: t.TempDir()}, false, true},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            r := httptest.NewRequest("POST", "/", strings.NewReader(content))
+            r.ContentLength = -1
+            b, err := bufferBody(r, c.rb)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if b.replayable() != c.replayable {
+                t.Errorf("replayable is %t; want %t", b.replayable(), c.replayable)
+            }
+            if (b.file != nil) != c.file {
+                t.Errorf("body in file is %t; want %t", b.file != nil, c.file)
+            }
+
+            tries := 1
+            if c.replayable {
+                tries = 2
+                if b.contentLength() != int64(len(content)) {
+                    t.Errorf("content length is %d; want %d", b.contentLength(), len(content))
+                }
+            }
+            for i := 0; i < tries; i++ {
+                if data := readAll(t, b); data != content {
+                    t.Errorf("%d] body is '%s'; want '%s'", i, data, content)
+                }
+            }
+
+            b.Close()
+            if b.file != nil {
+                if _, err := os.Stat(b.file.Name()); !os.IsNotExist(err) {
+                    t.Errorf("temporary file '%s' is not removed", b.file.Name())
+                }
+            }
+        })
+    }
+
+    t.Run("Empty", func (t *testing.T) {
+        r := httptest.NewRequest("GET", "/", nil)
+        b, err := bufferBody(r, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !b.replayable() || b.reader() != nil {
+            t.Errorf("empty body is not replayable")
+        }
+    })
+
+    t.Run("KnownLength", func (t *testing.T) {
+        r := httptest.NewRequest("POST", "/", strings.NewReader(content))
+        b, err := bufferBody(r, &RequestBuffering{MaxSize: 50})
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer b.Close()
+        if b.replayable() {
+            t.Errorf("body larger than MaxSize is replayable")
+        }
+        if b.contentLength() != int64(len(content)) {
+            t.Errorf("content length is %d; want %d", b.contentLength(), len(content))
+        }
+    })
+}
+
+func TestBufferBody_Errors(t *testing.T) {
+    content := strings.Repeat("0123456789", 10)
+    broken := func () io.Reader {
+        return io.MultiReader(strings.NewReader(content), iotest.ErrReader(errors.New("connection reset")))
+    }
+    cases := []struct{
+        name string
+        body io.Reader
+        rb   *RequestBuffering
+        want error
+    }{
+        {"ReadMemory", broken(), &RequestBuffering{}, BadRequestError},
+        {"ReadFile", broken(), &RequestBuffering{MemoryLimit: 10, TempDir: t.TempDir()}, BadRequestError},
+        {"TooLarge", strings.NewReader(content), &RequestBuffering{MemoryLimit: 10, TempDir: t.TempDir()}, RequestEntityTooLargeError},
+        {"TempDir", strings.NewReader(content), &RequestBuffering{MemoryLimit: 10, TempDir: "/nonexistent"}, InternalServerError},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            r := httptest.NewRequest("POST", "/", c.body)
+            r.ContentLength = -1
+            if c.want == RequestEntityTooLargeError {
+                r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 50)
+            }
+            _, err := bufferBody(r, c.rb)
+            if !errors.Is(err, c.want) {
+                t.Errorf("error is '%v'; want '%v'", err, c.want)
+            }
+        })
+    }
+}
+
+func TestProxy_RequestBuffering(t *testing.T) {
+    content := strings.Repeat("body", 100)
+    bodies := make(chan string, 2)
+    handler := func (status int) http.Handler {
+        return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+            data, _ := io.ReadAll(r.Body)
+            bodies <- string(data)
+            w.WriteHeader(status)
+        })
+    }
+    failing := httptest.NewServer(handler(502))
+    defer failing.Close()
+    working := httptest.NewServer(handler(200))
+    defer working.Close()
+
+    servers := []*UpstreamServer{
+        NewUpstreamServer(failing.URL, 1).SetMaxErrors(0),
+        NewUpstreamServer(working.URL, 1).SetMaxErrors(0),
+    }
+    proxy := NewProxy(NewUpstream(servers, &StrategyRoundRobin{}))
+    proxy.NextUpstream = NextUpstreamPolicy{Conditions: NextUpstreamHTTP502|NextUpstreamNonIdempotent}
+    proxy.RequestBuffering = &RequestBuffering{MemoryLimit: 16, TempDir: t.TempDir()}
+    defer proxy.Stop()
+
+    w := httptest.NewRecorder()
+    proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(content)))
+
+    if w.Code != 200 {
+        t.Fatalf("status is %d; want %d", w.Code, 200)
+    }
+    for i := 0; i < 2; i++ {
+        if body := <-bodies; body != content {
+            t.Errorf("%d] server got body of %d bytes; want %d", i, len(body), len(content))
+        }
+    }
+
+    t.Run("Errors", func (t *testing.T) {
+        cases := []struct{
+            name    string
+            tempDir string
+            limit   int64
+            want    int
+        }{
+            {"TooLarge", t.TempDir(), 100, 413},
+            {"TempDir", "/nonexistent", 0, 500},
+        }
+
+        for _, c := range cases {
+            proxy.RequestBuffering = &RequestBuffering{MemoryLimit: 16, TempDir: c.tempDir}
+            w := httptest.NewRecorder()
+            r := httptest.NewRequest("POST", "/", strings.NewReader(content))
+            if c.limit > 0 {
+                r.Body = http.MaxBytesReader(w, r.Body, c.limit)
+            }
+            proxy.GetHandler().ServeHTTP(w, r)
+            if w.Code != c.want {
+                t.Errorf("%s: status is %d; want %d", c.name, w.Code, c.want)
+            }
+        }
+    })
+
+    t.Run("ErrorHandler", func (t *testing.T) {
+        var got error
+        proxy.ErrorHandler = func (w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+            got = err
+            DefaultErrorHandler(w, r, server, err)
+        }
+        defer func() { proxy.ErrorHandler = nil }()
+        proxy.RequestBuffering = &RequestBuffering{MemoryLimit: 16, TempDir: "/nonexistent"}
+
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(content)))
+        var perr *ProxyError
+        if !errors.As(got, &perr) || perr.Status != 500 || !errors.Is(perr, InternalServerError) {
+            t.Errorf("error handler got '%v'; want internal server error", got)
+        }
+    })
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// A CertificateStore holds TLS certificates loaded from files and chooses
+// them by SNI. Changed files are loaded again by Reload, connections
+// established with old certificates are not affected.
+type CertificateStore struct {
+    pairs []*certificatePair
+
+    // names maps lower case DNS names and wildcards to certificates.
+    names map[string]*tls.Certificate
+
+    mux sync.RWMutex
+}
+
+// certificatePair is certificate loaded from certificate and key files.
+type certificatePair struct {
+    certFile string
+    keyFile  string
+
+    // certMod and keyMod are modification times of loaded files.
+    certMod time.Time
+    keyMod  time.Time
+
+    cert *tls.Certificate
+}
+
+// NewCertificateStore returns empty CertificateStore.
+func NewCertificateStore() *CertificateStore {
+    return &CertificateStore{
+        names: make(map[string]*tls.Certificate),
+    }
+}
+
+// Add loads PEM encoded certificate and key from files. Certificate added
+// first is used when client doesn't send SNI or no certificate matches it.
+func (s *CertificateStore) Add(certFile, keyFile string) error {
+    pair := &certificatePair{certFile: certFile, keyFile: keyFile}
+    if err := pair.load(); err != nil {
+        return err
+    }
+
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.pairs = append(s.pairs, pair)
+    s.index()
+    return nil
+}
+
+// Reload loads again certificates whose files changed. Certificate which
+// can't be loaded is kept and its loading is repeated by next Reload.
+func (s *CertificateStore) Reload() error {
+    s.mux.RLock()
+    pairs := make([]*certificatePair, len(s.pairs))
+    copy(pairs, s.pairs)
+    s.mux.RUnlock()
+
+    var errs []error
+    loaded := make(map[*certificatePair]*certificatePair)
+    for _, p := range pairs {
+        changed, err := p.changed()
+        if err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        if !changed {
+            continue
+        }
+        np := &certificatePair{certFile: p.certFile, keyFile: p.keyFile}
+        if err := np.load(); err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        loaded[p] = np
+    }
+
+    if len(loaded) > 0 {
+        s.mux.Lock()
+        for i, p := range s.pairs {
+            if np, ok := loaded[p]; ok {
+                s.pairs[i] = np
+            }
+        }
+        s.index()
+        s.mux.Unlock()
+    }
+    return errors.Join(errs...)
+}
+
+// GetCertificate returns certificate for client hello. It's suitable for
+// tls.Config.GetCertificate.
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    s.mux.RLock()
+    defer s.mux.RUnlock()
+    if len(s.pairs) == 0 {
+        return nil, errors.New("no certificates")
+    }
+
+    name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+    if cert, ok := s.names[name]; ok {
+        return cert, nil
+    }
+    if i := strings.IndexByte(name, '.'); i > 0 {
+        if cert, ok := s.names["*" + name[i:]]; ok {
+            return cert, nil
+        }
+    }
+    return s.pairs[0].cert, nil
+}
+
+// index rebuilds names of certificates. Caller must hold s.mux. Names of
+// certificates added earlier take precedence.
+func (s *CertificateStore) index() {
+    names := make(map[string]*tls.Certificate)
+    for _, p := range s.pairs {
+        for _, name := range p.names() {
+            if _, ok := names[name]; !ok {
+                names[name] = p.cert
+            }
+        }
+    }
+    s.names = names
+}
+
+// load reads certificate and key files.
+func (p *certificatePair) load() error {
+    certMod, keyMod, err := p.modTimes()
+    if err != nil {
+        return err
+    }
+    cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+    if err != nil {
+        return fmt.Errorf("can't load certificate %s: %w", p.certFile, err)
+    }
+    if cert.Leaf == nil {
+        if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+            return fmt.Errorf("can't load certificate %s: %w", p.certFile, err)
+        }
+    }
+    p.cert = &cert
+    p.certMod = certMod
+    p.keyMod = keyMod
+    return nil
+}
+
+// changed returns true if files were modified after loading.
+func (p *certificatePair) changed() (bool, error) {
+    certMod, keyMod, err := p.modTimes()
+    if err != nil {
+        return false, err
+    }
+    return !certMod.Equal(p.certMod) || !keyMod.Equal(p.keyMod), nil
+}
+
+// modTimes returns modification times of certificate and key files.
+func (p *certificatePair) modTimes() (time.Time, time.Time, error) {
+    ci, err := os.Stat(p.certFile)
+    if err != nil {
+        return time.Time{}, time.Time{}, err
+    }
+    ki, err := os.Stat(p.keyFile)
+    if err != nil {
+        return time.Time{}, time.Time{}, err
+    }
+    return ci.ModTime(), ki.ModTime(), nil
+}
+
+// names returns lower case names certificate is valid for. Common name is
+// used only if certificate has no DNS names.
+func (p *certificatePair) names() []string {
+    leaf := p.cert.Leaf
+    names := leaf.DNSNames
+    if len(names) == 0 && leaf.Subject.CommonName != "" {
+        names = []string{leaf.Subject.CommonName}
+    }
+    ret := make([]string, 0, len(names))
+    for _, name := range names {
+        ret = append(ret, strings.ToLower(name))
+    }
+    return ret
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/pem"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// writeTestCertificate writes self-signed certificate valid for names and
+// its key to dir and returns files names.
+func writeTestCertificate(t *testing.T, dir string, names ...string) (string, string) {
+    cert := newTestCertificate(t, names...)
+    key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    certFile := filepath.Join(dir, names[0] + ".pem")
+    keyFile := filepath.Join(dir, names[0] + ".key")
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+    keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+    if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+        t.Fatal(err)
+    }
+    return certFile, keyFile
+}
+
+func TestCertificateStore_GetCertificate(t *testing.T) {
+    dir := t.TempDir()
+    store := NewCertificateStore()
+    if _, err := store.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+        t.Errorf("empty store returns certificate")
+    }
+
+    for _, names := range [][]string{
+        {"default.test"},
+        {"example.com", "www.example.com"},
+        {"*.example.com"},
+    } {
+        if err := store.Add(writeTestCertificate(t, dir, names...)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    cases := []struct{
+        sni  string
+        want string
+    }{
+        {"", "default.test"},
+        {"unknown.test", "default.test"},
+        {"example.com", "example.com"},
+        {"WWW.Example.com.", "example.com"},
+        {"api.example.com", "*.example.com"},
+        {"a.b.example.com", "default.test"},
+    }
+
+    for _, c := range cases {
+        cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: c.sni})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if cn := cert.Leaf.Subject.CommonName; cn != c.want {
+            t.Errorf("certificate for '%s' is '%s'; want '%s'", c.sni, cn, c.want)
+        }
+    }
+
+    if err := store.Add(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing.key")); err == nil {
+        t.Errorf("missing certificate is added")
+    }
+}
+
+func TestCertificateStore_Reload(t *testing.T) {
+    dir := t.TempDir()
+    certFile, keyFile := writeTestCertificate(t, dir, "example.com")
+    store := NewCertificateStore()
+    if err := store.Add(certFile, keyFile); err != nil {
+        t.Fatal(err)
+    }
+    hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+    old, _ := store.GetCertificate(hello)
+
+    t.Run("Unchanged", func (t *testing.T) {
+        if err := store.Reload(); err != nil {
+            t.Fatal(err)
+        }
+        if cert, _ := store.GetCertificate(hello); cert != old {
+            t.Errorf("unchanged certificate is reloaded")
+        }
+    })
+
+    t.Run("Invalid", func (t *testing.T) {
+        os.WriteFile(certFile, []byte("invalid"), 0600)
+        future := time.Now().Add(time.Minute)
+        os.Chtimes(certFile, future, future)
+        if err := store.Reload(); err == nil {
+            t.Errorf("invalid certificate is reloaded without error")
+        }
+        if cert, _ := store.GetCertificate(hello); cert != old {
+            t.Errorf("certificate is replaced with invalid one")
+        }
+    })
+
+    t.Run("Changed", func (t *testing.T) {
+        writeTestCertificate(t, dir, "example.com")
+        future := time.Now().Add(time.Minute * 2)
+        os.Chtimes(certFile, future, future)
+        if err := store.Reload(); err != nil {
+            t.Fatal(err)
+        }
+        cert, _ := store.GetCertificate(hello)
+        if cert == old || cert.Leaf.Equal(old.Leaf) {
+            t.Errorf("changed certificate is not reloaded")
+        }
+    })
+}
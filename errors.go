@@ -0,0 +1,164 @@
+package proxy
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+)
+
+var InternalServerError error = errors.New("internal server error")
+var GatewayTimeoutError error = errors.New("gateway timeout")
+var BadGatewayError error = errors.New("bad gateway")
+var ServiceUnavailableError error = errors.New("service unavailable")
+var NotImplementedError error = errors.New("not implemented")
+var BadRequestError error = errors.New("bad request")
+var RequestEntityTooLargeError error = errors.New("request entity too large")
+
+// A ProxyError describes failed proxying of request.
+type ProxyError struct {
+    // Status is HTTP status code returned to client.
+    Status int
+
+    // Server is upstream server chosen for request. It's nil if no server
+    // was chosen.
+    Server *UpstreamServer
+
+    // Err is classified error, it wraps one of InternalServerError,
+    // GatewayTimeoutError, BadGatewayError, ServiceUnavailableError,
+    // NotImplementedError, BadRequestError or RequestEntityTooLargeError.
+    Err    error
+}
+
+// Error returns error message.
+func (e *ProxyError) Error() string {
+    if e.Server == nil {
+        return fmt.Sprintf("proxy: %v", e.Err)
+    }
+    return fmt.Sprintf("proxy: upstream [%s] : %v", e.Server, e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *ProxyError) Unwrap() error {
+    return e.Err
+}
+
+// classifyError wraps err with error describing client response:
+// ServiceUnavailableError if there is no server to process request,
+// GatewayTimeoutError on timeouts, BadGatewayError on connection and
+// protocol errors.
+func classifyError(err error) error {
+    switch {
+    case errors.Is(err, InternalServerError),
+        errors.Is(err, GatewayTimeoutError),
+        errors.Is(err, BadGatewayError),
+        errors.Is(err, ServiceUnavailableError),
+        errors.Is(err, NotImplementedError),
+        errors.Is(err, BadRequestError),
+        errors.Is(err, RequestEntityTooLargeError):
+        return err
+    case isTimeout(err):
+        return fmt.Errorf("%w: %w", err, GatewayTimeoutError)
+    default:
+        return fmt.Errorf("%w: %w", err, BadGatewayError)
+    }
+}
+
+// errorStatus returns HTTP status code for classified error.
+func errorStatus(err error) int {
+    var perr *ProxyError
+    if errors.As(err, &perr) && perr.Status != 0 {
+        return perr.Status
+    }
+
+    switch {
+    case errors.Is(err, ServiceUnavailableError):
+        return http.StatusServiceUnavailable
+    case errors.Is(err, GatewayTimeoutError):
+        return http.StatusGatewayTimeout
+    case errors.Is(err, BadGatewayError):
+        return http.StatusBadGateway
+    case errors.Is(err, NotImplementedError):
+        return http.StatusNotImplemented
+    case errors.Is(err, BadRequestError):
+        return http.StatusBadRequest
+    case errors.Is(err, RequestEntityTooLargeError):
+        return http.StatusRequestEntityTooLarge
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// ErrorHandler renders error response to client. The err is *ProxyError and
+// server is upstream server chosen for request or nil.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error)
+
+// DefaultErrorHandler replies with plain text status message.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+    status := errorStatus(err)
+    http.Error(w, http.StatusText(status), status)
+}
+
+// JSONErrorHandler replies with JSON object like
+// {"status":502,"error":"Bad Gateway"}.
+func JSONErrorHandler(w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+    status := errorStatus(err)
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(struct{
+        Status int    `json:"status"`
+        Error  string `json:"error"`
+    }{status, http.StatusText(status)})
+}
+
+// Template is implemented by text/template and html/template templates.
+type Template interface {
+    Execute(w io.Writer, data any) error
+}
+
+// ErrorData is data passed to error response template.
+type ErrorData struct {
+    // Status is HTTP status code.
+    Status     int
+
+    // StatusText is HTTP status text.
+    StatusText string
+
+    // Request is client request.
+    Request    *http.Request
+
+    // Server is upstream server chosen for request or nil.
+    Server     *UpstreamServer
+
+    // Err is classified error.
+    Err        error
+}
+
+// NewTemplateErrorHandler returns ErrorHandler rendering tmpl with ErrorData
+// as response body with specified content type. Use html/template to render
+// HTML pages.
+func NewTemplateErrorHandler(tmpl Template, contentType string) ErrorHandler {
+    return func (w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+        status := errorStatus(err)
+        var buf bytes.Buffer
+        terr := tmpl.Execute(&buf, ErrorData{
+            Status: status,
+            StatusText: http.StatusText(status),
+            Request: r,
+            Server: server,
+            Err: err,
+        })
+        if terr != nil {
+            DefaultErrorHandler(w, r, server, err)
+            return
+        }
+
+        w.Header().Set("Content-Type", contentType)
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        w.WriteHeader(status)
+        w.Write(buf.Bytes())
+    }
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "html/template"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+// timeoutError is net.Error reporting timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+    cases := []struct{
+        name   string
+        err    error
+        status int
+    }{
+        {"Refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, 502},
+        {"Reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, 502},
+        {"DialTimeout", &net.OpError{Op: "dial", Err: timeoutError{}}, 504},
+        {"Deadline", context.DeadlineExceeded, 504},
+        {"Unavailable", ServiceUnavailableError, 503},
+        {"Internal", InternalServerError, 500},
+        {"NotImplemented", NotImplementedError, 501},
+    }
+
+    for _, c := range cases {
+        err := classifyError(c.err)
+        if !errors.Is(err, c.err) {
+            t.Errorf("%s: classified error doesn't wrap original error", c.name)
+        }
+        if status := errorStatus(err); status != c.status {
+            t.Errorf("%s: status is %d; want %d", c.name, status, c.status)
+        }
+    }
+}
+
+func TestProxy_ErrorHandler(t *testing.T) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dead := NewUpstreamServer("http://" + l.Addr().String(), 1)
+    l.Close()
+
+    var gotServer *UpstreamServer
+    var gotErr error
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{dead}, &StrategyRoundRobin{}))
+    proxy.ErrorHandler = func (w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+        gotServer = server
+        gotErr = err
+        DefaultErrorHandler(w, r, server, err)
+    }
+    defer proxy.Stop()
+
+    t.Run("BadGateway", func (t *testing.T) {
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+        if w.Code != 502 {
+            t.Errorf("status is %d; want %d", w.Code, 502)
+        }
+        if gotServer != dead {
+            t.Errorf("server is '%v'; want '%v'", gotServer, dead)
+        }
+        var perr *ProxyError
+        if !errors.As(gotErr, &perr) || perr.Status != 502 || !errors.Is(gotErr, BadGatewayError) {
+            t.Errorf("error is '%v'; want *ProxyError with bad gateway", gotErr)
+        }
+    })
+
+    t.Run("ServiceUnavailable", func (t *testing.T) {
+        // server is offline after error
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+        if w.Code != 503 {
+            t.Errorf("status is %d; want %d", w.Code, 503)
+        }
+        if gotServer != nil {
+            t.Errorf("server is '%v'; want nil", gotServer)
+        }
+        if !errors.Is(gotErr, ServiceUnavailableError) {
+            t.Errorf("error is '%v'; want service unavailable", gotErr)
+        }
+    })
+}
+
+func TestErrorHandlers(t *testing.T) {
+    server := NewUpstreamServer("http://127.0.0.1:8080", 1)
+    err := &ProxyError{Status: 504, Server: server, Err: classifyError(context.DeadlineExceeded)}
+    r := httptest.NewRequest("GET", "/path", nil)
+
+    t.Run("JSON", func (t *testing.T) {
+        w := httptest.NewRecorder()
+        JSONErrorHandler(w, r, server, err)
+
+        var body struct{
+            Status int
+            Error  string
+        }
+        if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+            t.Fatal(err)
+        }
+        if w.Code != 504 || body.Status != 504 || body.Error != "Gateway Timeout" {
+            t.Errorf("response is %d '%s'; want %d JSON", w.Code, w.Body.String(), 504)
+        }
+        if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+            t.Errorf("Content-Type is '%s'; want JSON", w.Header().Get("Content-Type"))
+        }
+    })
+
+    t.Run("Template", func (t *testing.T) {
+        tmpl := template.Must(template.New("error").Parse(
+            `<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Request.URL.Path}} {{.Server}}</p>`))
+        w := httptest.NewRecorder()
+        NewTemplateErrorHandler(tmpl, "text/html; charset=utf-8")(w, r, server, err)
+
+        want := `<h1>504 Gateway Timeout</h1><p>/path http://127.0.0.1:8080</p>`
+        if w.Code != 504 || w.Body.String() != want {
+            t.Errorf("response is %d '%s'; want %d '%s'", w.Code, w.Body.String(), 504, want)
+        }
+    })
+
+    t.Run("TemplateError", func (t *testing.T) {
+        tmpl := template.Must(template.New("error").Parse(`{{.Missing}}`))
+        w := httptest.NewRecorder()
+        NewTemplateErrorHandler(tmpl, "text/html")(w, r, server, err)
+
+        if w.Code != 504 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+            t.Errorf("response is %d '%s'; want plain text fallback", w.Code, w.Header().Get("Content-Type"))
+        }
+    })
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+)
+
+// TrustedProxies is list of networks of proxies trusted to pass client
+// information in forwarding headers.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses CIDR networks or single IP addresses.
+func ParseTrustedProxies(networks ...string) (TrustedProxies, error) {
+    tp := make(TrustedProxies, 0, len(networks))
+    for _, n := range networks {
+        if !strings.Contains(n, "/") {
+            ip := net.ParseIP(n)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid IP address %q", n)
+            }
+            bits := 8 * net.IPv4len
+            if ip.To4() == nil {
+                bits = 8 * net.IPv6len
+            }
+            n = fmt.Sprintf("%s/%d", n, bits)
+        }
+
+        _, ipnet, err := net.ParseCIDR(n)
+        if err != nil {
+            return nil, err
+        }
+        tp = append(tp, ipnet)
+    }
+    return tp, nil
+}
+
+// Contains returns true if ip belongs to trusted networks.
+func (tp TrustedProxies) Contains(ip net.IP) bool {
+    for _, n := range tp {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// ClientIP returns address of client sent request r. If request came from
+// trusted proxy, X-Forwarded-For header is walked from right to left until
+// first untrusted address.
+func (tp TrustedProxies) ClientIP(r *http.Request) net.IP {
+    ip := remoteIP(r)
+    if ip == nil || !tp.Contains(ip) {
+        return ip
+    }
+
+    addrs := forwardedFor(r.Header)
+    for i := len(addrs) - 1; i >= 0; i-- {
+        fip := net.ParseIP(addrs[i])
+        if fip == nil {
+            break
+        }
+        ip = fip
+        if !tp.Contains(ip) {
+            break
+        }
+    }
+    return ip
+}
+
+// remoteIP returns IP address of request's peer.
+func remoteIP(r *http.Request) net.IP {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    return net.ParseIP(host)
+}
+
+// forwardedFor returns addresses listed in X-Forwarded-For headers.
+func forwardedFor(h http.Header) []string {
+    addrs := []string{}
+    for _, v := range h.Values("X-Forwarded-For") {
+        for _, a := range strings.Split(v, ",") {
+            if a = strings.TrimSpace(a); a != "" {
+                addrs = append(addrs, a)
+            }
+        }
+    }
+    return addrs
+}
+
+// A Forwarding defines headers passing client information to upstream
+// servers. Forwarding headers received from untrusted clients are replaced.
+type Forwarding struct {
+    // XForwarded enables X-Forwarded-For, X-Forwarded-Proto,
+    // X-Forwarded-Host and X-Forwarded-Port headers.
+    XForwarded bool
+
+    // Forwarded enables RFC 7239 Forwarded header.
+    Forwarded bool
+
+    // TrustedProxies lists proxies whose forwarding headers are kept and
+    // appended.
+    TrustedProxies TrustedProxies
+}
+
+// forwardingHeaders lists headers managed by Forwarding.
+var forwardingHeaders = []string{
+    "X-Forwarded-For",
+    "X-Forwarded-Proto",
+    "X-Forwarded-Host",
+    "X-Forwarded-Port",
+    "Forwarded",
+}
+
+// apply sets forwarding headers h of request passed to upstream server.
+func (f *Forwarding) apply(r *http.Request, h http.Header) {
+    ip := remoteIP(r)
+    trusted := ip != nil && f.TrustedProxies.Contains(ip)
+    if !trusted {
+        for _, name := range forwardingHeaders {
+            h.Del(name)
+        }
+    }
+
+    client := r.RemoteAddr
+    if ip != nil {
+        client = ip.String()
+    }
+    proto := "http"
+    if r.TLS != nil {
+        proto = "https"
+    }
+
+    if f.XForwarded {
+        if prior := strings.Join(forwardedFor(h), ", "); prior != "" {
+            h.Set("X-Forwarded-For", prior + ", " + client)
+        } else {
+            h.Set("X-Forwarded-For", client)
+        }
+        if h.Get("X-Forwarded-Proto") == "" {
+            h.Set("X-Forwarded-Proto", proto)
+        }
+        if h.Get("X-Forwarded-Host") == "" {
+            h.Set("X-Forwarded-Host", r.Host)
+        }
+        if h.Get("X-Forwarded-Port") == "" {
+            h.Set("X-Forwarded-Port", localPort(r, proto))
+        }
+    }
+
+    if f.Forwarded {
+        node := client
+        if ip != nil && ip.To4() == nil {
+            node = "[" + node + "]"
+        }
+        elem := fmt.Sprintf("for=%s;host=%s;proto=%s",
+            forwardedValue(node), forwardedValue(r.Host), proto)
+
+        if prior := strings.Join(h.Values("Forwarded"), ", "); prior != "" {
+            elem = prior + ", " + elem
+        }
+        h.Set("Forwarded", elem)
+    }
+}
+
+// localPort returns port which received request r.
+func localPort(r *http.Request, proto string) string {
+    if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+        if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+            return port
+        }
+    }
+    if _, port, err := net.SplitHostPort(r.Host); err == nil {
+        return port
+    }
+    if proto == "https" {
+        return "443"
+    }
+    return "80"
+}
+
+// forwardedValue returns RFC 7239 parameter value, it's quoted if it
+// contains characters not allowed in token.
+func forwardedValue(v string) string {
+    if v == "" {
+        return `""`
+    }
+    for _, c := range v {
+        if !isTokenChar(c) {
+            return fmt.Sprintf("%q", v)
+        }
+    }
+    return v
+}
+
+// isTokenChar returns true if c is allowed in RFC 7230 token.
+func isTokenChar(c rune) bool {
+    if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+        return true
+    }
+    return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+    tp, err := ParseTrustedProxies("10.0.0.0/8", "192.0.2.1", "2001:db8::/32")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cases := map[string]bool{
+        "10.1.2.3": true,
+        "192.0.2.1": true,
+        "192.0.2.2": false,
+        "2001:db8::1": true,
+        "2001:db9::1": false,
+    }
+    for addr, want := range cases {
+        if got := tp.Contains(net.ParseIP(addr)); got != want {
+            t.Errorf("%s trusted is %t; want %t", addr, got, want)
+        }
+    }
+
+    if _, err := ParseTrustedProxies("not-an-ip"); err == nil {
+        t.Errorf("invalid address accepted")
+    }
+}
+
+func TestTrustedProxies_ClientIP(t *testing.T) {
+    tp, _ := ParseTrustedProxies("10.0.0.0/8")
+
+    cases := []struct{
+        remote string
+        xff    string
+        want   string
+    }{
+        {"203.0.113.5:1000", "198.51.100.1", "203.0.113.5"},
+        {"10.0.0.1:1000", "198.51.100.1", "198.51.100.1"},
+        {"10.0.0.1:1000", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+        {"10.0.0.1:1000", "198.51.100.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+        {"10.0.0.1:1000", "", "10.0.0.1"},
+    }
+
+    for _, c := range cases {
+        r := httptest.NewRequest("GET", "/", nil)
+        r.RemoteAddr = c.remote
+        if c.xff != "" {
+            r.Header.Set("X-Forwarded-For", c.xff)
+        }
+        if ip := tp.ClientIP(r); ip.String() != c.want {
+            t.Errorf("%s [%s]: client is '%s'; want '%s'", c.remote, c.xff, ip, c.want)
+        }
+    }
+}
+
+func TestForwarding_apply(t *testing.T) {
+    tp, _ := ParseTrustedProxies("10.0.0.0/8")
+    f := &Forwarding{XForwarded: true, Forwarded: true, TrustedProxies: tp}
+
+    newRequest := func (remote string) (*http.Request, http.Header) {
+        r := httptest.NewRequest("GET", "http://example.com/", nil)
+        r.RemoteAddr = remote
+        r.Header.Set("X-Forwarded-For", "198.51.100.1")
+        r.Header.Set("X-Forwarded-Proto", "https")
+        r.Header.Set("Forwarded", "for=198.51.100.1")
+        return r, r.Header.Clone()
+    }
+
+    t.Run("Untrusted", func (t *testing.T) {
+        r, h := newRequest("203.0.113.5:1000")
+        f.apply(r, h)
+
+        want := map[string]string{
+            "X-Forwarded-For": "203.0.113.5",
+            "X-Forwarded-Proto": "http",
+            "X-Forwarded-Host": "example.com",
+            "X-Forwarded-Port": "80",
+            "Forwarded": "for=203.0.113.5;host=example.com;proto=http",
+        }
+        for name, value := range want {
+            if h.Get(name) != value {
+                t.Errorf("%s is '%s'; want '%s'", name, h.Get(name), value)
+            }
+        }
+    })
+
+    t.Run("Trusted", func (t *testing.T) {
+        r, h := newRequest("10.0.0.1:1000")
+        f.apply(r, h)
+
+        want := map[string]string{
+            "X-Forwarded-For": "198.51.100.1, 10.0.0.1",
+            "X-Forwarded-Proto": "https",
+            "Forwarded": "for=198.51.100.1, for=10.0.0.1;host=example.com;proto=http",
+        }
+        for name, value := range want {
+            if h.Get(name) != value {
+                t.Errorf("%s is '%s'; want '%s'", name, h.Get(name), value)
+            }
+        }
+    })
+
+    t.Run("IPv6", func (t *testing.T) {
+        r, h := newRequest("[2001:db8::1]:1000")
+        r.Host = "example.com:8080"
+        f.apply(r, h)
+
+        want := `for="[2001:db8::1]";host="example.com:8080";proto=http`
+        if h.Get("Forwarded") != want {
+            t.Errorf("Forwarded is '%s'; want '%s'", h.Get("Forwarded"), want)
+        }
+        if h.Get("X-Forwarded-Port") != "8080" {
+            t.Errorf("X-Forwarded-Port is '%s'; want '%s'", h.Get("X-Forwarded-Port"), "8080")
+        }
+    })
+}
+
+func TestProxy_Forwarding(t *testing.T) {
+    var got http.Header
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        got = r.Header.Clone()
+    }))
+    defer backend.Close()
+
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(backend.URL, 1)}, &StrategyRoundRobin{}))
+    proxy.Forwarding = &Forwarding{XForwarded: true}
+    defer proxy.Stop()
+
+    r := httptest.NewRequest("GET", "/", nil)
+    r.Header.Set("X-Forwarded-For", "198.51.100.1")
+    proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), r)
+
+    if got.Get("X-Forwarded-For") != "192.0.2.1" {
+        t.Errorf("X-Forwarded-For is '%s'; want '%s'", got.Get("X-Forwarded-For"), "192.0.2.1")
+    }
+}
@@ -0,0 +1,378 @@
+package proxy
+
+import (
+    "errors"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "net/http"
+    "sort"
+    "sync"
+)
+
+// hashString returns 64-bit hash of s. FNV-1a result is additionally mixed
+// because FNV spreads similar short strings poorly.
+func hashString(s string) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte(s))
+    return mix64(h.Sum64())
+}
+
+// mix64 is splitmix64 finalizer.
+func mix64(x uint64) uint64 {
+    x ^= x >> 30
+    x *= 0xbf58476d1ce4e5b9
+    x ^= x >> 27
+    x *= 0x94d049bb133111eb
+    x ^= x >> 31
+    return x
+}
+
+// requestKey returns hashing key of request r.
+func requestKey(getKey func (r *http.Request) (string, error), r *http.Request) (string, error) {
+    if getKey == nil {
+        return "", errors.New("hashing strategy requires GetKey")
+    }
+    key, err := getKey(r)
+    if err != nil {
+        return "", fmt.Errorf("can't get hashing key: %w", err)
+    }
+    return key, nil
+}
+
+// A StrategyMaglev realises UpstreamStrategy. Server is selected by Maglev
+// hashing: keys are mapped through lookup table filled by servers in
+// proportion to their weights. Lookup is constant time and only a few keys
+// are remapped when servers are changed. When server of key is offline, key
+// falls back to available server chosen by rendezvous hashing, so lookup
+// time is proportional to servers count, not to table size.
+type StrategyMaglev struct {
+    // TableSize is size of lookup table, it must be prime and much
+    // greater than total weight of servers, SetServers rejects servers
+    // whose total weight reaches it. Default is 65537.
+    TableSize uint
+
+    // GetKey returns hashing key of request. It's required.
+    GetKey func (r *http.Request) (string, error)
+
+    table []*UpstreamServer
+
+    // servers and hashes of their names are used for fallback.
+    servers []*UpstreamServer
+    hashes  []uint64
+    mux     sync.Mutex
+}
+
+// SetServers builds new lookup table of servers.
+// Method is safe for concurrent access.
+func (s *StrategyMaglev) SetServers(servers []*UpstreamServer) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    if s.GetKey == nil {
+        return errors.New("maglev hashing requires GetKey")
+    }
+    if s.TableSize == 0 {
+        s.TableSize = 65537
+    }
+    if !isPrime(s.TableSize) {
+        return fmt.Errorf("maglev table size %d is not prime", s.TableSize)
+    }
+    // server with weight greater than table size fills whole table in its
+    // first turn
+    if total := totalWeight(servers); total >= uint64(s.TableSize) {
+        return fmt.Errorf("total weight %d of servers reaches maglev table size %d", total, s.TableSize)
+    }
+
+    hashes := make([]uint64, len(servers))
+    for i, srv := range servers {
+        hashes[i] = hashString(srv.String())
+    }
+    s.table = maglevTable(servers, uint64(s.TableSize))
+    s.servers = servers
+    s.hashes = hashes
+    return nil
+}
+
+// maglevTable returns lookup table of size m filled by servers. Every
+// server has its own permutation of table entries defined by offset and
+// skip, servers take their next preferred free entries in turn, weight
+// entries per turn.
+func maglevTable(servers []*UpstreamServer, m uint64) []*UpstreamServer {
+    if len(servers) == 0 {
+        return nil
+    }
+
+    offsets := make([]uint64, len(servers))
+    skips := make([]uint64, len(servers))
+    weights := make([]uint, len(servers))
+    for i, srv := range servers {
+        name := srv.String()
+        offsets[i] = hashString(name) % m
+        skips[i] = hashString(name + "#skip") % (m - 1) + 1
+        weights[i] = srv.Weight()
+        if weights[i] == 0 {
+            weights[i] = 1
+        }
+    }
+
+    table := make([]*UpstreamServer, m)
+    next := make([]uint64, len(servers))
+    filled := uint64(0)
+    for {
+        for i := range servers {
+            for w := uint(0); w < weights[i]; w++ {
+                // find server's next preferred free entry
+                c := (offsets[i] + next[i] * skips[i]) % m
+                for table[c] != nil {
+                    next[i]++
+                    c = (offsets[i] + next[i] * skips[i]) % m
+                }
+                table[c] = servers[i]
+                next[i]++
+                filled++
+                if filled == m {
+                    return table
+                }
+            }
+        }
+    }
+}
+
+// totalWeight returns sum of servers weights.
+func totalWeight(servers []*UpstreamServer) uint64 {
+    total := uint64(0)
+    for _, srv := range servers {
+        total += uint64(srv.Weight())
+    }
+    return total
+}
+
+// isPrime returns true if n is prime.
+func isPrime(n uint) bool {
+    if n < 2 {
+        return false
+    }
+    for d := uint(2); d * d <= n; d++ {
+        if n % d == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Next returns server of request's key or fallback server if it's
+// unavailable.
+// Method is safe for concurrent access.
+func (s *StrategyMaglev) Next(r *http.Request) (*UpstreamServer, error) {
+    s.mux.Lock()
+    table := s.table
+    servers := s.servers
+    hashes := s.hashes
+    getKey := s.GetKey
+    s.mux.Unlock()
+
+    key, err := requestKey(getKey, r)
+    if err != nil {
+        return nil, err
+    }
+    if len(table) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+
+    keyHash := hashString(key)
+    primary := table[keyHash % uint64(len(table))]
+    if available(r, primary) {
+        return primary, nil
+    }
+
+    var next *UpstreamServer
+    best := math.Inf(-1)
+    for i, srv := range servers {
+        if srv == primary || !available(r, srv) {
+            continue
+        }
+        if score := rendezvousScore(keyHash, hashes[i], srv.Weight()); score > best {
+            best = score
+            next = srv
+        }
+    }
+    if next == nil {
+        return nil, errors.New("no valid servers")
+    }
+    return next, nil
+}
+
+// A StrategyJumpHash realises UpstreamStrategy. Server is selected by Jump
+// Consistent Hash, which needs no memory besides servers list. Server with
+// weight N takes N consecutive buckets. Only a few keys are remapped when
+// servers are added to or removed from the end of list, removing server
+// from the middle remaps keys of all following servers. When server of key
+// is offline, key is hashed again with attempt number.
+type StrategyJumpHash struct {
+    // GetKey returns hashing key of request. It's required.
+    GetKey func (r *http.Request) (string, error)
+
+    servers []*UpstreamServer
+
+    // sums are cumulative weights of servers, server i takes buckets from
+    // sums[i-1] to sums[i].
+    sums    []uint64
+    mux     sync.Mutex
+}
+
+// maxJumpBuckets limits total weight of StrategyJumpHash servers, jump hash
+// works with 31-bit bucket numbers.
+const maxJumpBuckets = math.MaxInt32
+
+// SetServers sets servers and their cumulative weights.
+// Method is safe for concurrent access.
+func (s *StrategyJumpHash) SetServers(servers []*UpstreamServer) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    if s.GetKey == nil {
+        return errors.New("jump hashing requires GetKey")
+    }
+
+    sums := make([]uint64, len(servers))
+    total := uint64(0)
+    for i, srv := range servers {
+        total += uint64(srv.Weight())
+        sums[i] = total
+    }
+    if total > maxJumpBuckets {
+        return fmt.Errorf("total weight %d of servers exceeds %d jump hash buckets", total, maxJumpBuckets)
+    }
+    s.servers = servers
+    s.sums = sums
+    return nil
+}
+
+// jumpHash returns bucket of key among n buckets. It's algorithm of Lamping
+// and Veach "A Fast, Minimal Memory, Consistent Hash Algorithm".
+func jumpHash(key uint64, n int) int {
+    b, j := int64(-1), int64(0)
+    for j < int64(n) {
+        b = j
+        key = key * 2862933555777941757 + 1
+        j = int64(float64(b + 1) * (float64(int64(1) << 31) / float64((key >> 33) + 1)))
+    }
+    return int(b)
+}
+
+// Next returns server of request's key. Unavailable server is replaced by
+// server of key hashed with attempt number.
+// Method is safe for concurrent access.
+func (s *StrategyJumpHash) Next(r *http.Request) (*UpstreamServer, error) {
+    s.mux.Lock()
+    servers := s.servers
+    sums := s.sums
+    getKey := s.GetKey
+    s.mux.Unlock()
+
+    key, err := requestKey(getKey, r)
+    if err != nil {
+        return nil, err
+    }
+    if len(servers) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+
+    total := int(sums[len(sums) - 1])
+    hash := hashString(key)
+    for attempt := 0; attempt < len(servers); attempt++ {
+        b := uint64(jumpHash(hash, total))
+        i := sort.Search(len(sums), func (i int) bool { return sums[i] > b })
+        if srv := servers[i]; available(r, srv) {
+            return srv, nil
+        }
+        hash = mix64(hash + uint64(attempt) + 1)
+    }
+    for _, srv := range servers {
+        if available(r, srv) {
+            return srv, nil
+        }
+    }
+    return nil, errors.New("no valid servers")
+}
+
+// A StrategyRendezvous realises UpstreamStrategy. Server is selected by
+// weighted rendezvous (highest random weight) hashing: every server scores
+// request's key and available server with highest score is chosen. Only
+// keys of changed servers are remapped, lookup time is proportional to
+// servers count.
+type StrategyRendezvous struct {
+    // GetKey returns hashing key of request. It's required.
+    GetKey func (r *http.Request) (string, error)
+
+    servers []*UpstreamServer
+
+    // hashes are hashes of servers names.
+    hashes  []uint64
+    mux     sync.Mutex
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyRendezvous) SetServers(servers []*UpstreamServer) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    if s.GetKey == nil {
+        return errors.New("rendezvous hashing requires GetKey")
+    }
+    hashes := make([]uint64, len(servers))
+    for i, srv := range servers {
+        hashes[i] = hashString(srv.String())
+    }
+    s.servers = servers
+    s.hashes = hashes
+    return nil
+}
+
+// rendezvousScore returns score of server with weight and name hash
+// srvHash for key hash. Score is -weight / ln(h), where h is hash of key
+// and server in (0, 1), so share of keys of server is proportional to its
+// weight.
+func rendezvousScore(keyHash, srvHash uint64, weight uint) float64 {
+    h := mix64(keyHash ^ mix64(srvHash))
+    // 53 high bits give uniform float in (0, 1)
+    f := (float64(h >> 11) + 0.5) / (1 << 53)
+    return -float64(weight) / math.Log(f)
+}
+
+// Next returns available server with highest score for request's key.
+// Method is safe for concurrent access.
+func (s *StrategyRendezvous) Next(r *http.Request) (*UpstreamServer, error) {
+    s.mux.Lock()
+    servers := s.servers
+    hashes := s.hashes
+    getKey := s.GetKey
+    s.mux.Unlock()
+
+    key, err := requestKey(getKey, r)
+    if err != nil {
+        return nil, err
+    }
+    if len(servers) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+
+    keyHash := hashString(key)
+    var next *UpstreamServer
+    best := math.Inf(-1)
+    for i, srv := range servers {
+        if !available(r, srv) {
+            continue
+        }
+        if score := rendezvousScore(keyHash, hashes[i], srv.Weight()); score > best {
+            best = score
+            next = srv
+        }
+    }
+    if next == nil {
+        return nil, errors.New("no valid servers")
+    }
+    return next, nil
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "testing"
+)
+
+// hashingStrategies returns constructors of hashing strategies keyed by
+// request path.
+func hashingStrategies() map[string]func () UpstreamStrategy {
+    key := func (r *http.Request) (string, error) {
+        return r.URL.Path, nil
+    }
+    return map[string]func () UpstreamStrategy{
+        "Ketama": func () UpstreamStrategy { return &StrategyConsistentHashing{GetKey: key} },
+        "Maglev": func () UpstreamStrategy { return &StrategyMaglev{GetKey: key} },
+        "JumpHash": func () UpstreamStrategy { return &StrategyJumpHash{GetKey: key} },
+        "Rendezvous": func () UpstreamStrategy { return &StrategyRendezvous{GetKey: key} },
+    }
+}
+
+// hashingCluster returns n servers with weight 1.
+func hashingCluster(n int) []*UpstreamServer {
+    cluster := make([]*UpstreamServer, 0, n)
+    for i := 0; i < n; i++ {
+        cluster = append(cluster, NewUpstreamServer(fmt.Sprintf("http://10.0.%d.%d:80", i / 250, i % 250 + 1), 1))
+    }
+    return cluster
+}
+
+// hashingRequests returns n requests with different keys.
+func hashingRequests(n int) []*http.Request {
+    reqs := make([]*http.Request, n)
+    for i := range reqs {
+        reqs[i], _ = http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1/key/%d", i), nil)
+    }
+    return reqs
+}
+
+// hashingMapping returns servers chosen by strategy for requests.
+func hashingMapping(tb testing.TB, strategy UpstreamStrategy, reqs []*http.Request) []*UpstreamServer {
+    ret := make([]*UpstreamServer, len(reqs))
+    for i, r := range reqs {
+        srv, err := strategy.Next(r)
+        if err != nil {
+            tb.Fatal(err)
+        }
+        ret[i] = srv
+    }
+    return ret
+}
+
+// remapped returns share of keys mapped to different servers.
+func remapped(before, after []*UpstreamServer) float64 {
+    moved := 0
+    for i := range before {
+        if before[i] != after[i] {
+            moved++
+        }
+    }
+    return float64(moved) / float64(len(before))
+}
+
+// maxDeviation returns maximal relative deviation of servers keys counts
+// from counts proportional to weights.
+func maxDeviation(servers []*UpstreamServer, mapping []*UpstreamServer) float64 {
+    counts := make(map[*UpstreamServer]int)
+    for _, srv := range mapping {
+        counts[srv]++
+    }
+    total := 0
+    for _, srv := range servers {
+        total += int(srv.Weight())
+    }
+    dev := 0.0
+    for _, srv := range servers {
+        want := float64(len(mapping)) * float64(srv.Weight()) / float64(total)
+        dev = math.Max(dev, math.Abs(float64(counts[srv]) - want) / want)
+    }
+    return dev
+}
+
+func TestHashingStrategies(t *testing.T) {
+    reqs := hashingRequests(20000)
+
+    for name, newStrategy := range hashingStrategies() {
+        t.Run(name, func (t *testing.T) {
+            t.Run("Weights", func (t *testing.T) {
+                cluster := hashingCluster(4)
+                cluster[3].SetWeight(3)
+                strategy := newStrategy()
+                if err := strategy.SetServers(cluster); err != nil {
+                    t.Fatal(err)
+                }
+                if dev := maxDeviation(cluster, hashingMapping(t, strategy, reqs)); dev > 0.2 {
+                    t.Errorf("distribution deviation is %.2f; want at most 0.2", dev)
+                }
+            })
+
+            t.Run("Remap", func (t *testing.T) {
+                cluster := hashingCluster(10)
+                strategy := newStrategy()
+                strategy.SetServers(cluster[:9])
+                before := hashingMapping(t, strategy, reqs)
+                strategy.SetServers(cluster)
+                after := hashingMapping(t, strategy, reqs)
+
+                toOld := 0
+                for i := range before {
+                    if before[i] != after[i] && after[i] != cluster[9] {
+                        toOld++
+                    }
+                }
+                // Maglev moves few keys between old servers
+                if share := float64(toOld) / float64(len(reqs)); share > 0.02 {
+                    t.Errorf("share of keys moved between old servers is %.3f", share)
+                }
+                if share := remapped(before, after); share < 0.05 || share > 0.2 {
+                    t.Errorf("remapped share is %.3f; want about 0.1", share)
+                }
+            })
+
+            t.Run("SkipOffline", func (t *testing.T) {
+                cluster := hashingCluster(3)
+                strategy := newStrategy()
+                strategy.SetServers(cluster)
+                before := hashingMapping(t, strategy, reqs[:1000])
+                cluster[1].online = false
+                after := hashingMapping(t, strategy, reqs[:1000])
+                cluster[1].online = true
+
+                for i := range before {
+                    if after[i] == cluster[1] {
+                        t.Fatalf("offline server is returned")
+                    }
+                    if before[i] != cluster[1] && before[i] != after[i] {
+                        t.Fatalf("key of online server is remapped")
+                    }
+                }
+            })
+
+        })
+    }
+
+    t.Run("NoGetKey", func (t *testing.T) {
+        for _, strategy := range []UpstreamStrategy{&StrategyMaglev{}, &StrategyJumpHash{}, &StrategyRendezvous{}} {
+            if err := strategy.SetServers(hashingCluster(1)); err == nil {
+                t.Errorf("%T without GetKey accepts servers", strategy)
+            }
+            r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+            if _, err := strategy.Next(r); err == nil {
+                t.Errorf("%T without GetKey returns server", strategy)
+            }
+        }
+    })
+
+    t.Run("MaglevTableSize", func (t *testing.T) {
+        strategy := &StrategyMaglev{TableSize: 1000, GetKey: KeyURI()}
+        if err := strategy.SetServers(hashingCluster(3)); err == nil {
+            t.Errorf("table size which is not prime is accepted")
+        }
+
+        strategy.TableSize = 13
+        cluster := hashingCluster(2)
+        cluster[0].SetWeight(13)
+        if err := strategy.SetServers(cluster); err == nil {
+            t.Errorf("weight reaching table size is accepted")
+        }
+    })
+
+    t.Run("MaglevFallback", func (t *testing.T) {
+        strategy := &StrategyMaglev{GetKey: KeyPath()}
+        cluster := hashingCluster(10)
+        strategy.SetServers(cluster)
+        before := hashingMapping(t, strategy, reqs[:2000])
+
+        cluster[0].online = false
+        defer func() { cluster[0].online = true }()
+        after := hashingMapping(t, strategy, reqs[:2000])
+        again := hashingMapping(t, strategy, reqs[:2000])
+        targets := make(map[*UpstreamServer]bool)
+        for i := range before {
+            if after[i] != again[i] {
+                t.Fatalf("fallback server of key %d is not stable", i)
+            }
+            if before[i] == cluster[0] {
+                targets[after[i]] = true
+            }
+        }
+        // keys of offline server are spread over remaining servers
+        if len(targets) < 8 {
+            t.Errorf("fallback servers count is %d; want at least %d", len(targets), 8)
+        }
+
+        for _, srv := range cluster[1:] {
+            srv.online = false
+        }
+        defer func() {
+            for _, srv := range cluster[1:] {
+                srv.online = true
+            }
+        }()
+        if _, err := strategy.Next(reqs[0]); err == nil {
+            t.Errorf("server is returned when all servers are offline")
+        }
+    })
+
+    t.Run("JumpHashWeight", func (t *testing.T) {
+        strategy := &StrategyJumpHash{GetKey: KeyURI()}
+        cluster := hashingCluster(3)
+        cluster[0].SetWeight(1e9)
+        cluster[1].SetWeight(2e9)
+        if err := strategy.SetServers(cluster); err == nil {
+            t.Errorf("weight exceeding buckets limit is accepted")
+        }
+
+        // big weights don't allocate buckets
+        cluster[1].SetWeight(1e9)
+        if err := strategy.SetServers(cluster); err != nil {
+            t.Fatal(err)
+        }
+        counts := make(map[*UpstreamServer]int)
+        for _, r := range hashingRequests(1000) {
+            next, err := strategy.Next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            counts[next]++
+        }
+        if counts[cluster[2]] != 0 || counts[cluster[0]] < 400 || counts[cluster[1]] < 400 {
+            t.Errorf("counts are %d, %d, %d; want about %d, %d, %d",
+                counts[cluster[0]], counts[cluster[1]], counts[cluster[2]], 500, 500, 0)
+        }
+    })
+}
+
+func BenchmarkHashingStrategies(b *testing.B) {
+    reqs := hashingRequests(10000)
+    cluster := hashingCluster(100)
+
+    for name, newStrategy := range hashingStrategies() {
+        b.Run(name, func (b *testing.B) {
+            strategy := newStrategy()
+            strategy.SetServers(cluster)
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                strategy.Next(reqs[i % len(reqs)])
+            }
+        })
+
+        b.Run(name + "/Distribution", func (b *testing.B) {
+            var dev float64
+            for i := 0; i < b.N; i++ {
+                strategy := newStrategy()
+                strategy.SetServers(cluster)
+                dev = maxDeviation(cluster, hashingMapping(b, strategy, reqs))
+            }
+            b.ReportMetric(dev * 100, "maxdev%")
+        })
+
+        b.Run(name + "/Remap", func (b *testing.B) {
+            var share float64
+            for i := 0; i < b.N; i++ {
+                strategy := newStrategy()
+                strategy.SetServers(cluster[:len(cluster)-1])
+                before := hashingMapping(b, strategy, reqs)
+                strategy.SetServers(cluster)
+                share = remapped(before, hashingMapping(b, strategy, reqs))
+            }
+            b.ReportMetric(share * 100, "remap%")
+        })
+    }
+}
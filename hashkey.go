@@ -0,0 +1,214 @@
+package proxy
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+)
+
+var MissingKeyError error = errors.New("missing hashing key")
+
+// A KeyFunc returns hashing key of request for StrategyConsistentHashing.
+// It returns error wrapping MissingKeyError if request has no key.
+type KeyFunc func (r *http.Request) (string, error)
+
+// KeyURI returns key built from request path and query.
+func KeyURI() KeyFunc {
+    return func (r *http.Request) (string, error) {
+        return requestURI(r), nil
+    }
+}
+
+// KeyPath returns key built from request path without query.
+func KeyPath() KeyFunc {
+    return func (r *http.Request) (string, error) {
+        return r.URL.Path, nil
+    }
+}
+
+// KeyHeader returns key built from value of request header name.
+func KeyHeader(name string) KeyFunc {
+    return func (r *http.Request) (string, error) {
+        if v := r.Header.Get(name); v != "" {
+            return v, nil
+        }
+        return "", fmt.Errorf("header %s: %w", name, MissingKeyError)
+    }
+}
+
+// KeyCookie returns key built from value of request cookie name.
+func KeyCookie(name string) KeyFunc {
+    return func (r *http.Request) (string, error) {
+        if c, err := r.Cookie(name); err == nil && c.Value != "" {
+            return c.Value, nil
+        }
+        return "", fmt.Errorf("cookie %s: %w", name, MissingKeyError)
+    }
+}
+
+// KeyQuery returns key built from value of query parameter name.
+func KeyQuery(name string) KeyFunc {
+    return func (r *http.Request) (string, error) {
+        if v := r.URL.Query().Get(name); v != "" {
+            return v, nil
+        }
+        return "", fmt.Errorf("query parameter %s: %w", name, MissingKeyError)
+    }
+}
+
+// KeyClientIP returns key built from client address. Addresses passed by
+// trusted proxies in X-Forwarded-For header are honored, nil trusted means
+// address of request peer is used.
+func KeyClientIP(trusted TrustedProxies) KeyFunc {
+    return func (r *http.Request) (string, error) {
+        if ip := trusted.ClientIP(r); ip != nil {
+            return ip.String(), nil
+        }
+        return "", fmt.Errorf("client address: %w", MissingKeyError)
+    }
+}
+
+// KeyFallback returns key of first function which has key for request.
+func KeyFallback(keys ...KeyFunc) KeyFunc {
+    return func (r *http.Request) (string, error) {
+        errs := make([]error, 0, len(keys))
+        for _, key := range keys {
+            k, err := key(r)
+            if err == nil {
+                return k, nil
+            }
+            if !errors.Is(err, MissingKeyError) {
+                return "", err
+            }
+            errs = append(errs, err)
+        }
+        if len(errs) == 0 {
+            return "", MissingKeyError
+        }
+        return "", errors.Join(errs...)
+    }
+}
+
+// KeyTemplate returns key built from nginx-style template, for example
+// "$host$request_uri". Supported variables are $host, $http_host,
+// $request_uri, $uri, $args, $query_string, $scheme, $request_method,
+// $remote_addr, $arg_NAME, $http_NAME and $cookie_NAME. Variable name may be
+// enclosed in braces: "${host}". Missing variables are empty, key which is
+// empty as whole is missing.
+func KeyTemplate(tmpl string) (KeyFunc, error) {
+    parts := make([]func (r *http.Request) string, 0)
+    for tmpl != "" {
+        i := strings.IndexByte(tmpl, '$')
+        if i < 0 {
+            i = len(tmpl)
+        }
+        if i > 0 {
+            text := tmpl[:i]
+            parts = append(parts, func (*http.Request) string { return text })
+            tmpl = tmpl[i:]
+            continue
+        }
+
+        var name string
+        if strings.HasPrefix(tmpl, "${") {
+            j := strings.IndexByte(tmpl, '}')
+            if j < 0 {
+                return nil, fmt.Errorf("unclosed variable in template %q", tmpl)
+            }
+            name, tmpl = tmpl[2:j], tmpl[j+1:]
+        } else {
+            j := 1
+            for j < len(tmpl) && isVariableChar(tmpl[j]) {
+                j++
+            }
+            name, tmpl = tmpl[1:j], tmpl[j:]
+        }
+        part, err := templateVariable(name)
+        if err != nil {
+            return nil, err
+        }
+        parts = append(parts, part)
+    }
+
+    return func (r *http.Request) (string, error) {
+        var b strings.Builder
+        for _, part := range parts {
+            b.WriteString(part(r))
+        }
+        if b.Len() == 0 {
+            return "", fmt.Errorf("template: %w", MissingKeyError)
+        }
+        return b.String(), nil
+    }, nil
+}
+
+// isVariableChar returns true if c is allowed in variable name.
+func isVariableChar(c byte) bool {
+    return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// templateVariable returns function returning value of variable name.
+func templateVariable(name string) (func (r *http.Request) string, error) {
+    switch name {
+    case "host":
+        return func (r *http.Request) string {
+            host := r.Host
+            if h, _, err := net.SplitHostPort(host); err == nil {
+                host = h
+            }
+            return strings.ToLower(host)
+        }, nil
+    case "http_host":
+        return func (r *http.Request) string { return r.Host }, nil
+    case "request_uri":
+        return requestURI, nil
+    case "uri":
+        return func (r *http.Request) string { return r.URL.Path }, nil
+    case "args", "query_string":
+        return func (r *http.Request) string { return r.URL.RawQuery }, nil
+    case "scheme":
+        return func (r *http.Request) string {
+            if r.TLS != nil {
+                return "https"
+            }
+            return "http"
+        }, nil
+    case "request_method":
+        return func (r *http.Request) string { return r.Method }, nil
+    case "remote_addr":
+        return func (r *http.Request) string {
+            if ip := remoteIP(r); ip != nil {
+                return ip.String()
+            }
+            return ""
+        }, nil
+    }
+
+    switch {
+    case strings.HasPrefix(name, "arg_") && len(name) > 4:
+        arg := name[4:]
+        return func (r *http.Request) string { return r.URL.Query().Get(arg) }, nil
+    case strings.HasPrefix(name, "http_") && len(name) > 5:
+        header := strings.ReplaceAll(name[5:], "_", "-")
+        return func (r *http.Request) string { return r.Header.Get(header) }, nil
+    case strings.HasPrefix(name, "cookie_") && len(name) > 7:
+        cookie := name[7:]
+        return func (r *http.Request) string {
+            if c, err := r.Cookie(cookie); err == nil {
+                return c.Value
+            }
+            return ""
+        }, nil
+    }
+    return nil, fmt.Errorf("unknown variable $%s", name)
+}
+
+// requestURI returns request path and query as sent by client.
+func requestURI(r *http.Request) string {
+    if r.RequestURI != "" {
+        return r.RequestURI
+    }
+    return r.URL.RequestURI()
+}
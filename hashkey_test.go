@@ -0,0 +1,143 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestKeyFunc(t *testing.T) {
+    trusted, _ := ParseTrustedProxies("10.0.0.0/8")
+    r, _ := http.NewRequest("GET", "http://Example.com:8080/a/b?id=42&x=1", nil)
+    r.RequestURI = "/a/b?id=42&x=1"
+    r.RemoteAddr = "10.0.0.1:5000"
+    r.Header.Set("X-User", "alice")
+    r.Header.Set("X-Forwarded-For", "192.0.2.1")
+    r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+
+    cases := []struct{
+        name    string
+        key     KeyFunc
+        want    string
+        missing bool
+    }{
+        {"URI", KeyURI(), "/a/b?id=42&x=1", false},
+        {"Path", KeyPath(), "/a/b", false},
+        {"Header", KeyHeader("X-User"), "alice", false},
+        {"HeaderMissing", KeyHeader("X-Tenant"), "", true},
+        {"Cookie", KeyCookie("session"), "s1", false},
+        {"CookieMissing", KeyCookie("user"), "", true},
+        {"Query", KeyQuery("id"), "42", false},
+        {"QueryMissing", KeyQuery("page"), "", true},
+        {"ClientIP", KeyClientIP(nil), "10.0.0.1", false},
+        {"TrustedClientIP", KeyClientIP(trusted), "192.0.2.1", false},
+        {"Fallback", KeyFallback(KeyCookie("user"), KeyHeader("X-User")), "alice", false},
+        {"FallbackMissing", KeyFallback(KeyCookie("user"), KeyQuery("page")), "", true},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            key, err := c.key(r)
+            if c.missing {
+                if !errors.Is(err, MissingKeyError) {
+                    t.Errorf("error is '%v'; want '%v'", err, MissingKeyError)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if key != c.want {
+                t.Errorf("key is '%s'; want '%s'", key, c.want)
+            }
+        })
+    }
+}
+
+func TestKeyTemplate(t *testing.T) {
+    r, _ := http.NewRequest("POST", "http://Example.com:8080/a/b?id=42&x=1", nil)
+    r.RequestURI = "/a/b?id=42&x=1"
+    r.RemoteAddr = "192.0.2.1:5000"
+    r.Header.Set("X-User", "alice")
+    r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+    tr, _ := http.NewRequest("GET", "https://example.com/", nil)
+    tr.TLS = &tls.ConnectionState{}
+
+    cases := []struct{
+        tmpl string
+        r    *http.Request
+        want string
+    }{
+        {"$host$request_uri", r, "example.com/a/b?id=42&x=1"},
+        {"$http_host", r, "Example.com:8080"},
+        {"$uri?$args", r, "/a/b?id=42&x=1"},
+        {"$query_string", r, "id=42&x=1"},
+        {"${request_method}:${arg_id}", r, "POST:42"},
+        {"user-$http_x_user", r, "user-alice"},
+        {"$cookie_session/$remote_addr", r, "s1/192.0.2.1"},
+        {"$scheme://$host", tr, "https://example.com"},
+        {"$arg_missing", tr, ""},
+    }
+
+    for _, c := range cases {
+        key, err := KeyTemplate(c.tmpl)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := key(c.r)
+        if c.want == "" {
+            if !errors.Is(err, MissingKeyError) {
+                t.Errorf("%s: error is '%v'; want '%v'", c.tmpl, err, MissingKeyError)
+            }
+            continue
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got != c.want {
+            t.Errorf("%s: key is '%s'; want '%s'", c.tmpl, got, c.want)
+        }
+    }
+
+    for _, tmpl := range []string{"$unknown", "${host", "$"} {
+        if _, err := KeyTemplate(tmpl); err == nil {
+            t.Errorf("invalid template '%s' is accepted", tmpl)
+        }
+    }
+}
+
+func TestStrategyConsistentHashing_GetKey(t *testing.T) {
+    strategy := &StrategyConsistentHashing{}
+    if err := strategy.SetServers(servers); err == nil {
+        t.Errorf("strategy without GetKey accepts servers")
+    }
+    r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+    if _, err := strategy.Next(r); err == nil {
+        t.Errorf("strategy without GetKey returns server")
+    }
+    if _, err := NewUpstreamWithOptions(servers, strategy, UpstreamOptions{}); err == nil {
+        t.Errorf("upstream with strategy without GetKey is created")
+    }
+    // NewUpstream doesn't panic, upstream has no servers
+    u := NewUpstream(servers, strategy)
+    if len(u.Servers()) != 0 {
+        t.Errorf("servers count is %d; want %d", len(u.Servers()), 0)
+    }
+    proxy := NewProxy(u)
+    defer proxy.Stop()
+    w := httptest.NewRecorder()
+    proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+    if w.Code != http.StatusServiceUnavailable {
+        t.Errorf("status is %d; want %d", w.Code, http.StatusServiceUnavailable)
+    }
+
+    strategy.GetKey = KeyFallback(KeyHeader("X-User"), KeyURI())
+    if err := strategy.SetServers(servers); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := strategy.Next(r); err != nil {
+        t.Errorf("no next server: %v", err)
+    }
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+    "net/http"
+    "net/textproto"
+    "strings"
+)
+
+// hopHeaders are hop-by-hop headers, they are meaningful only for single
+// connection and are not passed through proxy (RFC 7230, section 6.1).
+// Headers with "Proxy-" prefix are removed too.
+var hopHeaders = []string{
+    "Connection",
+    "Keep-Alive",
+    "Te",
+    "Trailer",
+    "Transfer-Encoding",
+    "Upgrade",
+}
+
+// copyHeaders copying headers between http.Header instances. Every value of
+// multi-value header is kept as separate value.
+func copyHeaders(src, dst http.Header) {
+    for k, vv := range src {
+        for _, v := range vv {
+            dst.Add(k, v)
+        }
+    }
+}
+
+// removeHopHeaders removes hop-by-hop headers and headers listed in
+// Connection header.
+func removeHopHeaders(h http.Header) {
+    for _, v := range h["Connection"] {
+        for _, name := range strings.Split(v, ",") {
+            if name = textproto.TrimString(name); name != "" {
+                h.Del(name)
+            }
+        }
+    }
+
+    for _, name := range hopHeaders {
+        h.Del(name)
+    }
+
+    for name := range h {
+        if strings.HasPrefix(name, "Proxy-") {
+            delete(h, name)
+        }
+    }
+}
+
+// headerHasToken returns true if comma separated values of header name
+// contain token. Comparison is case-insensitive.
+func headerHasToken(h http.Header, name, token string) bool {
+    for _, v := range h.Values(name) {
+        for _, t := range strings.Split(v, ",") {
+            if strings.EqualFold(textproto.TrimString(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// requestHeaders returns headers of request r passed to upstream server.
+func requestHeaders(r *http.Request) http.Header {
+    h := make(http.Header, len(r.Header))
+    copyHeaders(r.Header, h)
+    removeHopHeaders(h)
+
+    // "TE: trailers" tells server that client accepts trailers, gRPC
+    // requires it.
+    if headerHasToken(r.Header, "Te", "trailers") {
+        h.Set("Te", "trailers")
+    }
+
+    return h
+}
+
+// responseHeaders copies headers of upstream response res to client
+// response headers dst and announces response trailers.
+func responseHeaders(res *http.Response, dst http.Header) {
+    h := res.Header.Clone()
+    removeHopHeaders(h)
+    copyHeaders(h, dst)
+
+    if len(res.Trailer) > 0 {
+        keys := make([]string, 0, len(res.Trailer))
+        for k := range res.Trailer {
+            keys = append(keys, k)
+        }
+        dst.Add("Trailer", strings.Join(keys, ", "))
+        // HTTP/2 response may have both length and trailers, HTTP/1.1
+        // client gets trailers only with chunked encoding
+        dst.Del("Content-Length")
+    }
+}
+
+// responseTrailers copies upstream response trailers to client response
+// headers. Trailers not announced before response body are sent with
+// http.TrailerPrefix.
+func responseTrailers(res *http.Response, announced int, dst http.Header) {
+    if len(res.Trailer) == announced {
+        copyHeaders(res.Trailer, dst)
+        return
+    }
+
+    for k, vv := range res.Trailer {
+        for _, v := range vv {
+            dst.Add(http.TrailerPrefix + k, v)
+        }
+    }
+}
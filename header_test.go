@@ -0,0 +1,118 @@
+package proxy
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+    h := http.Header{
+        "Connection": {"keep-alive, X-Private", "Upgrade"},
+        "Keep-Alive": {"timeout=5"},
+        "X-Private": {"secret"},
+        "Upgrade": {"websocket"},
+        "Te": {"trailers"},
+        "Transfer-Encoding": {"chunked"},
+        "Trailer": {"X-Sum"},
+        "Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+        "Proxy-Connection": {"keep-alive"},
+        "Set-Cookie": {"a=1", "b=2"},
+        "X-Public": {"1"},
+    }
+    removeHopHeaders(h)
+
+    want := http.Header{
+        "Set-Cookie": {"a=1", "b=2"},
+        "X-Public": {"1"},
+    }
+    if !reflect.DeepEqual(h, want) {
+        t.Errorf("headers are %v; want %v", h, want)
+    }
+}
+
+func TestCopyHeaders(t *testing.T) {
+    src := http.Header{"Set-Cookie": {"a=1", "b=2"}}
+    dst := http.Header{"Set-Cookie": {"c=3"}}
+    copyHeaders(src, dst)
+
+    want := []string{"c=3", "a=1", "b=2"}
+    if !reflect.DeepEqual(dst["Set-Cookie"], want) {
+        t.Errorf("Set-Cookie is %v; want %v", dst["Set-Cookie"], want)
+    }
+}
+
+func TestProxy_Headers(t *testing.T) {
+    var got http.Header
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        got = r.Header.Clone()
+        w.Header().Add("Set-Cookie", "a=1")
+        w.Header().Add("Set-Cookie", "b=2")
+        w.Header().Set("Connection", "X-Hop")
+        w.Header().Set("X-Hop", "1")
+        w.Header().Set("Trailer", "X-Sum")
+        w.WriteHeader(200)
+        io.WriteString(w, "hello")
+        w.Header().Set("X-Sum", "42")
+        w.Header().Set(http.TrailerPrefix + "X-Late", "1")
+    }))
+    defer backend.Close()
+
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(backend.URL, 1)}, &StrategyRoundRobin{}))
+    defer proxy.Stop()
+    front := httptest.NewServer(proxy.GetHandler())
+    defer front.Close()
+
+    req, _ := http.NewRequest("GET", front.URL, nil)
+    req.Header.Set("Connection", "X-Secret")
+    req.Header.Set("X-Secret", "1")
+    req.Header.Set("Te", "trailers, deflate")
+    req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+    req.Header.Add("Accept", "text/html")
+    req.Header.Add("Accept", "application/json")
+    res, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer res.Body.Close()
+    body, _ := io.ReadAll(res.Body)
+
+    t.Run("Request", func (t *testing.T) {
+        for _, name := range []string{"X-Secret", "Proxy-Authorization", "Connection"} {
+            if got.Get(name) != "" {
+                t.Errorf("%s is passed to server", name)
+            }
+        }
+        if got.Get("Te") != "trailers" {
+            t.Errorf("Te is '%s'; want '%s'", got.Get("Te"), "trailers")
+        }
+        want := []string{"text/html", "application/json"}
+        if !reflect.DeepEqual(got["Accept"], want) {
+            t.Errorf("Accept is %v; want %v", got["Accept"], want)
+        }
+    })
+
+    t.Run("Response", func (t *testing.T) {
+        want := []string{"a=1", "b=2"}
+        if !reflect.DeepEqual(res.Header["Set-Cookie"], want) {
+            t.Errorf("Set-Cookie is %v; want %v", res.Header["Set-Cookie"], want)
+        }
+        if res.Header.Get("X-Hop") != "" {
+            t.Errorf("X-Hop is passed to client")
+        }
+        if string(body) != "hello" {
+            t.Errorf("body is '%s'; want '%s'", body, "hello")
+        }
+    })
+
+    t.Run("Trailers", func (t *testing.T) {
+        if res.Trailer.Get("X-Sum") != "42" {
+            t.Errorf("X-Sum trailer is '%s'; want '%s'", res.Trailer.Get("X-Sum"), "42")
+        }
+        if res.Trailer.Get("X-Late") != "1" {
+            t.Errorf("X-Late trailer is '%s'; want '%s'", res.Trailer.Get("X-Late"), "1")
+        }
+    })
+}
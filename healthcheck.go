@@ -0,0 +1,226 @@
+package proxy
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "regexp"
+    "sync"
+    "time"
+)
+
+// maxCheckBody is maximum number of body bytes read to match HealthCheck.Body.
+const maxCheckBody = 64 * 1024
+
+// A HealthCheck defines parameters of active servers health checks.
+// Servers are probed with GET requests every Interval. Server goes offline
+// after Fall consecutive failed checks and goes online after Rise consecutive
+// successful checks.
+type HealthCheck struct {
+    // Path is requested on every server after server's path prefix, Host
+    // header follows upstream host policy. Default is "/".
+    Path string
+
+    // Interval is time between checks. Default is 5 seconds.
+    Interval time.Duration
+
+    // Timeout is maximum duration of single check. Default is 1 second.
+    Timeout time.Duration
+
+    // Status lists expected response status codes. If empty, any 2xx or 3xx
+    // status is expected.
+    Status []int
+
+    // Body if not nil must match response body.
+    Body *regexp.Regexp
+
+    // Rise is successful checks count required to mark server online.
+    // Default is 1.
+    Rise uint
+
+    // Fall is failed checks count required to mark server offline.
+    // Default is 1.
+    Fall uint
+}
+
+// setDefaults fills empty parameters with default values.
+func (hc *HealthCheck) setDefaults() {
+    if hc.Path == "" {
+        hc.Path = "/"
+    }
+    if hc.Interval <= 0 {
+        hc.Interval = time.Second * 5
+    }
+    if hc.Timeout <= 0 {
+        hc.Timeout = time.Second
+    }
+    if hc.Rise == 0 {
+        hc.Rise = 1
+    }
+    if hc.Fall == 0 {
+        hc.Fall = 1
+    }
+}
+
+// expected returns true if status is expected.
+func (hc *HealthCheck) expected(status int) bool {
+    if len(hc.Status) == 0 {
+        return status >= 200 && status < 400
+    }
+
+    for _, s := range hc.Status {
+        if s == status {
+            return true
+        }
+    }
+    return false
+}
+
+// check probes server once using transport rt and returns error if server is
+// unhealthy. Probe is sent to the same URL as proxied request to Path with
+// Host header host, empty host means server's host:port. Redirects are not
+// followed.
+func (hc *HealthCheck) check(ctx context.Context, server *UpstreamServer, rt http.RoundTripper, host string) error {
+    ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+    defer cancel()
+
+    path, err := url.Parse(hc.Path)
+    if err != nil {
+        return err
+    }
+    target := targetURL(server, path)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+    if err != nil {
+        return err
+    }
+    req.URL = target
+    req.Host = host
+
+    res, err := rt.RoundTrip(req)
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if !hc.expected(res.StatusCode) {
+        return fmt.Errorf("unexpected status %d", res.StatusCode)
+    }
+
+    if hc.Body != nil {
+        body, err := io.ReadAll(io.LimitReader(res.Body, maxCheckBody))
+        if err != nil {
+            return err
+        }
+        if !hc.Body.Match(body) {
+            return fmt.Errorf("body doesn't match %q", hc.Body.String())
+        }
+    }
+
+    return nil
+}
+
+// SetHealthCheck sets copy of active health checks parameters. Nil disables
+// checks and makes all servers healthy. Checks use upstream transport. It
+// takes effect on next StartHealthChecks.
+func (u *Upstream) SetHealthCheck(hc *HealthCheck) *Upstream {
+    u.mux.Lock()
+    if hc != nil {
+        c := *hc
+        c.setDefaults()
+        hc = &c
+    }
+    u.healthCheck = hc
+    u.mux.Unlock()
+
+    if hc == nil {
+        u.resetHealth()
+    }
+    return u
+}
+
+// HealthCheck returns active health checks parameters.
+func (u *Upstream) HealthCheck() *HealthCheck {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.healthCheck
+}
+
+// StartHealthChecks starts goroutine probing servers every HealthCheck
+// Interval. It does nothing if HealthCheck is not set or checks are already
+// started.
+func (u *Upstream) StartHealthChecks() {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    if u.healthCheck == nil || u.checksStop != nil {
+        return
+    }
+
+    hc := u.healthCheck
+    stop := make(chan struct{})
+    u.checksStop = stop
+    u.checksWg.Add(1)
+    go func() {
+        defer u.checksWg.Done()
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go func() {
+            <-stop
+            cancel()
+        }()
+
+        ticker := time.NewTicker(hc.Interval)
+        defer ticker.Stop()
+        for {
+            u.checkHealth(ctx, hc)
+            select {
+            case <-ticker.C:
+            case <-stop:
+                return
+            }
+        }
+    }()
+}
+
+// StopHealthChecks stops goroutine started by StartHealthChecks, waits for
+// running checks and makes all servers healthy.
+func (u *Upstream) StopHealthChecks() {
+    u.mux.Lock()
+    if u.checksStop == nil {
+        u.mux.Unlock()
+        return
+    }
+    close(u.checksStop)
+    u.checksStop = nil
+    u.mux.Unlock()
+
+    u.checksWg.Wait()
+    u.resetHealth()
+}
+
+// resetHealth forgets health checks results of all servers, so servers
+// aren't kept offline by checks which don't run.
+func (u *Upstream) resetHealth() {
+    for _, s := range u.Servers() {
+        s.resetHealth()
+    }
+}
+
+// checkHealth probes all servers concurrently and waits for results.
+func (u *Upstream) checkHealth(ctx context.Context, hc *HealthCheck) {
+    var wg sync.WaitGroup
+    for _, s := range u.Servers() {
+        wg.Add(1)
+        go func(s *UpstreamServer) {
+            defer wg.Done()
+            err := hc.check(ctx, s, u.transportFor(s), u.checkHost())
+            if ctx.Err() != nil {
+                // check was interrupted by stopping
+                return
+            }
+            s.checked(err == nil, hc.Rise, hc.Fall)
+        }(s)
+    }
+    wg.Wait()
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "regexp"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestHealthCheck_check(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/health":
+            w.Write([]byte("status: ok"))
+        case "/moved":
+            http.Redirect(w, r, "/health", http.StatusFound)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer backend.Close()
+    server := NewUpstreamServer(backend.URL, 1)
+
+    cases := []struct{
+        name string
+        hc   HealthCheck
+        ok   bool
+    }{
+        {"Default", HealthCheck{Path: "/health"}, true},
+        {"NotFound", HealthCheck{Path: "/missing"}, false},
+        {"Redirect", HealthCheck{Path: "/moved"}, true},
+        {"Status", HealthCheck{Path: "/moved", Status: []int{200}}, false},
+        {"Body", HealthCheck{Path: "/health", Body: regexp.MustCompile("ok$")}, true},
+        {"BodyMismatch", HealthCheck{Path: "/health", Body: regexp.MustCompile("fail")}, false},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            c.hc.setDefaults()
+            err := c.hc.check(context.Background(), server, http.DefaultTransport, "")
+            if (err == nil) != c.ok {
+                t.Errorf("check error is '%v'; want ok %t", err, c.ok)
+            }
+        })
+    }
+}
+
+func TestUpstream_checkHealth(t *testing.T) {
+    var healthy atomic.Bool
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        if !healthy.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer backend.Close()
+
+    server := NewUpstreamServer(backend.URL, 1)
+    u := NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{})
+    u.SetHealthCheck(&HealthCheck{Rise: 2, Fall: 2})
+    hc := u.HealthCheck()
+    ctx := context.Background()
+
+    steps := []struct{
+        healthy bool
+        online  bool
+    }{
+        {false, true},
+        {false, false},
+        {true, false},
+        {true, true},
+        {false, true},
+        {true, true},
+    }
+
+    for i, step := range steps {
+        healthy.Store(step.healthy)
+        u.checkHealth(ctx, hc)
+        if server.Online() != step.online {
+            t.Fatalf("%d] online is %t; want %t", i, server.Online(), step.online)
+        }
+    }
+
+    t.Run("ErrorsTimeout", func (t *testing.T) {
+        clock := &testClock{now: time.Unix(1000, 0)}
+        u.SetClock(clock)
+        u.failed(server)
+        if server.Online() {
+            t.Fatalf("server is online after error; want offline")
+        }
+
+        // healthy server stays offline until errors timeout expires
+        u.checkHealth(ctx, hc)
+        u.checkHealth(ctx, hc)
+        if server.Online() {
+            t.Fatalf("server is online before errors timeout; want offline")
+        }
+
+        clock.Add(time.Second * time.Duration(server.ErrorsTimeout()))
+        u.checkServers()
+        if !server.Online() {
+            t.Fatalf("server is offline after errors timeout; want online")
+        }
+    })
+}
+
+func TestUpstream_checkHealth_Target(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/app/health" || r.URL.RawQuery != "full=1" || r.Host != "backend.local" {
+            http.NotFound(w, r)
+        }
+    }))
+    defer backend.Close()
+
+    server := NewUpstreamServer(backend.URL + "/app", 1)
+    opts := UpstreamOptions{HostPolicy: HostFixed, Host: "backend.local"}
+    u, err := NewUpstreamWithOptions([]*UpstreamServer{server}, &StrategyRoundRobin{}, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    u.SetHealthCheck(&HealthCheck{Path: "/health?full=1"})
+
+    u.checkHealth(context.Background(), u.HealthCheck())
+    if !server.Online() {
+        t.Errorf("server is offline; want online")
+    }
+}
+
+func TestProxy_HealthChecks(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+    defer backend.Close()
+
+    server := NewUpstreamServer(backend.URL, 1)
+    u := NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{})
+    u.SetHealthCheck(&HealthCheck{Interval: time.Millisecond * 10})
+    proxy := NewProxy(u)
+
+    proxy.Start()
+    deadline := time.Now().Add(time.Second * 5)
+    for server.Online() && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond * 10)
+    }
+    if server.Online() {
+        t.Errorf("failing server is online; want offline")
+    }
+    proxy.Stop()
+
+    if u.checksStop != nil {
+        t.Errorf("health checks are not stopped")
+    }
+    // server isn't kept offline by stopped checks
+    if !server.Healthy() || !server.Online() {
+        t.Errorf("server is offline after stopping checks; want online")
+    }
+}
+
+func TestUpstream_SetHealthCheck(t *testing.T) {
+    server := NewUpstreamServer("http://127.0.0.1:8080", 1)
+    u := NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{})
+
+    t.Run("Copy", func (t *testing.T) {
+        hc := &HealthCheck{Path: "/health"}
+        u.SetHealthCheck(hc)
+        if hc.Interval != 0 || hc.Rise != 0 {
+            t.Errorf("caller's health check is modified")
+        }
+        if u.HealthCheck() == hc || u.HealthCheck().Interval == 0 {
+            t.Errorf("health check isn't copied with defaults")
+        }
+    })
+
+    t.Run("Disable", func (t *testing.T) {
+        hc := u.HealthCheck()
+        for i := uint(0); i < hc.Fall; i++ {
+            server.checked(false, hc.Rise, hc.Fall)
+        }
+        if server.Online() {
+            t.Fatalf("unhealthy server is online; want offline")
+        }
+
+        u.SetHealthCheck(nil)
+        if !server.Healthy() || !server.Online() {
+            t.Errorf("server is offline after disabling checks; want online")
+        }
+    })
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+    "context"
+    "errors"
+    "io"
+    "math"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// latencyDecay is time constant of servers latency averages: weight of
+// measurement drops e times every latencyDecay.
+const latencyDecay = time.Second * 10
+
+// latencyPenalty is latency assumed for server without measurements which
+// already has active connections, so new servers aren't flooded by
+// requests before their first response. It's also minimum latency recorded
+// for failed responses, so fast failing server doesn't attract requests.
+const latencyPenalty = time.Second
+
+// failureLatency returns latency recorded for response failed after d.
+func failureLatency(d time.Duration) time.Duration {
+    return max(d, latencyPenalty)
+}
+
+// An ewma is exponentially weighted moving average of latency. Weight of
+// measurement depends on time since previous one, so rare and frequent
+// measurements age equally. Peak average jumps up to greater measurements
+// immediately and goes down smoothly.
+type ewma struct {
+    // value is average in nanoseconds.
+    value float64
+
+    // stamp is moment of last measurement, zero if there are none.
+    stamp time.Time
+
+    peak  bool
+}
+
+// observe adds measurement d made at now.
+func (e *ewma) observe(now time.Time, d time.Duration) {
+    v := float64(d)
+    if e.stamp.IsZero() || (e.peak && v > e.value) {
+        e.value = v
+        e.stamp = now
+        return
+    }
+
+    w := math.Exp(-float64(now.Sub(e.stamp)) / float64(latencyDecay))
+    if w > 1 {
+        // clock went back
+        w = 1
+    }
+    e.value = e.value * w + v * (1 - w)
+    e.stamp = now
+}
+
+// get returns average at now. Average decays toward zero while there are
+// no measurements, so stale slow server gets requests again.
+func (e *ewma) get(now time.Time) float64 {
+    if e.stamp.IsZero() {
+        return 0
+    }
+    elapsed := now.Sub(e.stamp)
+    if elapsed <= 0 {
+        return e.value
+    }
+    return e.value * math.Exp(-float64(elapsed) / float64(latencyDecay))
+}
+
+// latencyCost returns cost of sending request to server with average
+// latency avg: it's latency multiplied by connections count including new
+// request, in proportion to weight.
+func latencyCost(srv *UpstreamServer, avg float64) float64 {
+    conns := float64(srv.Connections())
+    if avg == 0 && conns > 0 {
+        avg = float64(latencyPenalty)
+    }
+    return avg * (conns + 1) / float64(srv.Weight())
+}
+
+// compareCost returns comparison function of servers by latencyCost with
+// average returned by avg.
+func compareCost(avg func (srv *UpstreamServer) float64) func (a, b *UpstreamServer) int {
+    return func (a, b *UpstreamServer) int {
+        x, y := latencyCost(a, avg(a)), latencyCost(b, avg(b))
+        switch {
+        case x < y:
+            return -1
+        case x > y:
+            return 1
+        }
+        return 0
+    }
+}
+
+// clockNow returns current time of c, or system time if c is nil.
+func clockNow(c Clock) time.Time {
+    if c == nil {
+        return time.Now()
+    }
+    return c.Now()
+}
+
+// latencyBody is response body recording total response time of server
+// when body is read till the end. Truncated body is recorded as failure
+// unless request context ctx is done.
+type latencyBody struct {
+    io.ReadCloser
+    ctx    context.Context
+    server *UpstreamServer
+    now    func () time.Time
+    start  time.Time
+    done   bool
+}
+
+// Read reads response body and records total response time on EOF or
+// error.
+func (b *latencyBody) Read(p []byte) (int, error) {
+    n, err := b.ReadCloser.Read(p)
+    if err == nil || b.done {
+        return n, err
+    }
+
+    b.done = true
+    now := b.now()
+    d := now.Sub(b.start)
+    switch {
+    case err == io.EOF:
+        b.server.observeTotal(now, d)
+    case b.ctx.Err() == nil:
+        b.server.observeTotal(now, failureLatency(d))
+    }
+    return n, err
+}
+
+// A StrategyLeastTime realises UpstreamStrategy. It's nginx least_time:
+// request is passed to server with least average response time multiplied
+// by active connections count, in proportion to weight. Average is time to
+// first byte of response, or time to last byte if LastByte is set. Servers
+// without measurements are tried first.
+type StrategyLeastTime struct {
+    // LastByte makes strategy use time of whole response instead of time
+    // to response headers.
+    LastByte bool
+
+    // Clock is source of time used to decay averages. Default is system
+    // clock, it should be the same as clock of upstream.
+    Clock Clock
+
+    mux     sync.Mutex
+    servers []*UpstreamServer
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyLeastTime) SetServers(servers []*UpstreamServer) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.servers = servers
+    return nil
+}
+
+// Next returns available server with least cost, random one of equal
+// servers.
+// Method is safe for concurrent access.
+func (s *StrategyLeastTime) Next(r *http.Request) (*UpstreamServer, error) {
+    s.mux.Lock()
+    servers := s.servers
+    s.mux.Unlock()
+    if len(servers) < 1 {
+        return nil, errors.New("empty upstreams")
+    }
+
+    now := clockNow(s.Clock)
+    return pickLeast(r, servers, compareCost(func (srv *UpstreamServer) float64 {
+        return srv.averageLatency(now, s.LastByte)
+    }))
+}
+
+// A StrategyPeakEWMA realises UpstreamStrategy. It's Finagle and Linkerd
+// peak EWMA balancer: of two random servers one with less peak-sensitive
+// average time to first byte multiplied by active connections count is
+// chosen. Server turns slow for the strategy at once and turns fast
+// gradually. Next takes no locks besides servers ones.
+type StrategyPeakEWMA struct {
+    // Clock is source of time used to decay averages. Default is system
+    // clock, it should be the same as clock of upstream.
+    Clock Clock
+
+    servers atomic.Pointer[[]*UpstreamServer]
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyPeakEWMA) SetServers(servers []*UpstreamServer) error {
+    s.servers.Store(&servers)
+    return nil
+}
+
+// Next returns cheaper of two random available servers.
+// Method is safe for concurrent access.
+func (s *StrategyPeakEWMA) Next(r *http.Request) (*UpstreamServer, error) {
+    p := s.servers.Load()
+    if p == nil || len(*p) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+
+    now := clockNow(s.Clock)
+    return pickTwoChoices(r, *p, compareCost(func (srv *UpstreamServer) float64 {
+        return srv.peakLatency(now)
+    }))
+}
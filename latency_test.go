@@ -0,0 +1,232 @@
+package proxy
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestEWMA(t *testing.T) {
+    start := time.Unix(1000, 0)
+    ms := float64(time.Millisecond)
+
+    // 100ms, 300ms after latencyDecay and 100ms after latencyDecay, every
+    // previous average has weight 1/e
+    avg := 100 / math.E + 300 * (1 - 1 / math.E)
+    cases := []struct{
+        name string
+        peak bool
+        want float64
+    }{
+        {"Average", false, avg / math.E + 100 * (1 - 1 / math.E)},
+        // peak average jumps to 300ms at once
+        {"Peak", true, 300 / math.E + 100 * (1 - 1 / math.E)},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            e := ewma{peak: c.peak}
+            if v := e.get(start); v != 0 {
+                t.Errorf("empty average is %f; want %d", v, 0)
+            }
+            e.observe(start, time.Millisecond * 100)
+            if v := e.get(start); v != 100 * ms {
+                t.Errorf("average is %f; want %f", v / ms, 100.0)
+            }
+            if v := e.get(start.Add(latencyDecay)); math.Abs(v - 100 * ms / math.E) > 1 {
+                t.Errorf("decayed average is %f; want %f", v / ms, 100 / math.E)
+            }
+
+            e.observe(start.Add(latencyDecay), time.Millisecond * 300)
+            e.observe(start.Add(latencyDecay * 2), time.Millisecond * 100)
+            if v := e.get(start.Add(latencyDecay * 2)); math.Abs(v - c.want * ms) > 1 {
+                t.Errorf("average is %f; want %f", v / ms, c.want)
+            }
+        })
+    }
+}
+
+func TestStrategyLeastTime(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    clock := &testClock{now: time.Unix(1000, 0)}
+
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    a.observeTTFB(clock.Now(), time.Millisecond * 100)
+    a.observeTotal(clock.Now(), time.Millisecond * 500)
+    b.observeTTFB(clock.Now(), time.Millisecond * 300)
+    b.observeTotal(clock.Now(), time.Millisecond * 400)
+
+    strategy := &StrategyLeastTime{Clock: clock}
+    strategy.SetServers([]*UpstreamServer{a, b})
+
+    if next, _ := strategy.Next(r); next != a {
+        t.Errorf("server is '%s'; want '%s'", next, a)
+    }
+
+    t.Run("Connections", func (t *testing.T) {
+        // 100ms * 4 is more than 300ms * 1
+        for i := 0; i < 3; i++ {
+            a.incrConnections()
+            defer a.decrConnections()
+        }
+        if next, _ := strategy.Next(r); next != b {
+            t.Errorf("server is '%s'; want '%s'", next, b)
+        }
+    })
+
+    t.Run("Weight", func (t *testing.T) {
+        c := NewUpstreamServer("http://127.0.0.1:8002", 4)
+        c.observeTTFB(clock.Now(), time.Millisecond * 300)
+        strategy := &StrategyLeastTime{Clock: clock}
+        strategy.SetServers([]*UpstreamServer{a, c})
+        if next, _ := strategy.Next(r); next != c {
+            t.Errorf("server is '%s'; want '%s'", next, c)
+        }
+    })
+
+    t.Run("LastByte", func (t *testing.T) {
+        strategy := &StrategyLeastTime{Clock: clock, LastByte: true}
+        strategy.SetServers([]*UpstreamServer{a, b})
+        if next, _ := strategy.Next(r); next != b {
+            t.Errorf("server is '%s'; want '%s'", next, b)
+        }
+    })
+
+    t.Run("Unmeasured", func (t *testing.T) {
+        c := NewUpstreamServer("http://127.0.0.1:8002", 1)
+        strategy := &StrategyLeastTime{Clock: clock}
+        strategy.SetServers([]*UpstreamServer{a, b, c})
+        if next, _ := strategy.Next(r); next != c {
+            t.Errorf("server is '%s'; want '%s'", next, c)
+        }
+
+        // server waiting for first response costs latencyPenalty
+        c.incrConnections()
+        defer c.decrConnections()
+        if next, _ := strategy.Next(r); next != a {
+            t.Errorf("server is '%s'; want '%s'", next, a)
+        }
+    })
+
+    t.Run("Empty", func (t *testing.T) {
+        strategy := &StrategyLeastTime{}
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server is returned by empty strategy")
+        }
+    })
+}
+
+func TestStrategyPeakEWMA(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    clock := &testClock{now: time.Unix(1000, 0)}
+
+    fast := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    slow := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    fast.observeTTFB(clock.Now(), time.Millisecond * 10)
+    slow.observeTTFB(clock.Now(), time.Millisecond * 10)
+
+    strategy := &StrategyPeakEWMA{Clock: clock}
+    strategy.SetServers([]*UpstreamServer{fast, slow})
+
+    t.Run("Peak", func (t *testing.T) {
+        // single slow response makes server slow at once
+        clock.Add(time.Millisecond * 100)
+        slow.observeTTFB(clock.Now(), time.Second)
+        fast.observeTTFB(clock.Now(), time.Millisecond * 10)
+        for i := 0; i < 100; i++ {
+            if next, _ := strategy.Next(r); next != fast {
+                t.Fatalf("%d] server is '%s'; want '%s'", i, next, fast)
+            }
+        }
+    })
+
+    t.Run("Connections", func (t *testing.T) {
+        // 10ms * 101 is more than 1s
+        for i := 0; i < 100; i++ {
+            fast.incrConnections()
+            defer fast.decrConnections()
+        }
+        if next, _ := strategy.Next(r); next != slow {
+            t.Errorf("server is '%s'; want '%s'", next, slow)
+        }
+    })
+
+    t.Run("Decay", func (t *testing.T) {
+        // stale measurement of slow server decays below fresh one of fast
+        clock.Add(latencyDecay * 10)
+        fast.observeTTFB(clock.Now(), time.Millisecond * 10)
+        if next, _ := strategy.Next(r); next != slow {
+            t.Errorf("server is '%s'; want '%s'", next, slow)
+        }
+    })
+
+    t.Run("Empty", func (t *testing.T) {
+        strategy := &StrategyPeakEWMA{}
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server is returned by empty strategy")
+        }
+    })
+}
+
+func TestProxy_Latency(t *testing.T) {
+    // proxied returns server of backend h after proxying request to it.
+    proxied := func (t *testing.T, h http.HandlerFunc) *UpstreamServer {
+        backend := httptest.NewServer(h)
+        t.Cleanup(backend.Close)
+
+        server := NewUpstreamServer(backend.URL, 1).SetMaxErrors(0)
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{server}, &StrategyLeastTime{}))
+        defer proxy.Stop()
+        proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+        return server
+    }
+
+    t.Run("OK", func (t *testing.T) {
+        server := proxied(t, func (w http.ResponseWriter, r *http.Request) {
+            time.Sleep(time.Millisecond * 20)
+            w.WriteHeader(200)
+            w.(http.Flusher).Flush()
+            time.Sleep(time.Millisecond * 30)
+            fmt.Fprintf(w, "hello")
+        })
+
+        ttfb, total := server.Latency()
+        if ttfb < time.Millisecond * 20 || ttfb >= time.Millisecond * 50 {
+            t.Errorf("time to first byte is %s; want about %s", ttfb, time.Millisecond * 20)
+        }
+        if total < time.Millisecond * 50 || total >= latencyPenalty {
+            t.Errorf("total time is %s; want at least %s", total, time.Millisecond * 50)
+        }
+    })
+
+    t.Run("ServerError", func (t *testing.T) {
+        // fast failing server doesn't look fast
+        server := proxied(t, func (w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(500)
+        })
+
+        ttfb, total := server.Latency()
+        if ttfb < latencyPenalty || total < latencyPenalty {
+            t.Errorf("latency is %s and %s; want at least %s", ttfb, total, latencyPenalty)
+        }
+    })
+
+    t.Run("Truncated", func (t *testing.T) {
+        server := proxied(t, func (w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Content-Length", "100")
+            fmt.Fprintf(w, "hello")
+        })
+
+        ttfb, total := server.Latency()
+        if ttfb >= latencyPenalty {
+            t.Errorf("time to first byte is %s; want less than %s", ttfb, latencyPenalty)
+        }
+        if total < latencyPenalty {
+            t.Errorf("total time is %s; want at least %s", total, latencyPenalty)
+        }
+    })
+}
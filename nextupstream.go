@@ -0,0 +1,165 @@
+package proxy
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "time"
+)
+
+// NextUpstream is a set of conditions in which request is passed to the next
+// upstream server.
+type NextUpstream uint
+
+const (
+    // NextUpstreamError passes request to next server when connection to
+    // server fails or server closes connection without response.
+    NextUpstreamError NextUpstream = 1 << iota
+
+    // NextUpstreamTimeout passes request to next server on timeout.
+    NextUpstreamTimeout
+
+    // NextUpstreamHTTP502 passes request to next server when it responds
+    // with 502 status.
+    NextUpstreamHTTP502
+
+    // NextUpstreamHTTP503 passes request to next server when it responds
+    // with 503 status.
+    NextUpstreamHTTP503
+
+    // NextUpstreamHTTP504 passes request to next server when it responds
+    // with 504 status.
+    NextUpstreamHTTP504
+
+    // NextUpstreamNonIdempotent allows passing non-idempotent requests (POST,
+    // LOCK, PATCH) to next server when request was already sent to server.
+    NextUpstreamNonIdempotent
+
+    // NextUpstreamOff disables passing request to next server.
+    NextUpstreamOff
+)
+
+// DefaultNextUpstream is used when NextUpstreamPolicy.Conditions is zero.
+const DefaultNextUpstream = NextUpstreamError | NextUpstreamTimeout
+
+// A NextUpstreamPolicy defines when and how many times failed request is
+// passed to the next server. Same server is never tried twice for one
+// request. Errors and statuses matching Conditions are counted as server
+// failures for MaxErrors of any request method.
+type NextUpstreamPolicy struct {
+    // Conditions are situations in which request is passed to next server.
+    // Default is DefaultNextUpstream.
+    Conditions NextUpstream
+
+    // Tries limits number of tries. Zero means tries are limited only by
+    // number of servers.
+    Tries uint
+
+    // Timeout limits time during which request can be passed to next
+    // server. Zero means no limit.
+    Timeout time.Duration
+}
+
+// conditions returns policy conditions or default conditions.
+func (p NextUpstreamPolicy) conditions() NextUpstream {
+    if p.Conditions == 0 {
+        return DefaultNextUpstream
+    }
+    return p.Conditions
+}
+
+// more returns true if policy allows next try after tries made since start.
+func (p NextUpstreamPolicy) more(tries uint, start time.Time) bool {
+    if p.conditions()&NextUpstreamOff != 0 {
+        return false
+    }
+    if p.Tries > 0 && tries >= p.Tries {
+        return false
+    }
+    if p.Timeout > 0 && time.Since(start) >= p.Timeout {
+        return false
+    }
+    return true
+}
+
+// retryError returns true if request r failed with err can be passed to next
+// server.
+func (p NextUpstreamPolicy) retryError(r *http.Request, err error) bool {
+    if !p.failedError(r, err) {
+        return false
+    }
+    if isDialError(err) {
+        // request was not sent, so it's safe to retry any method
+        return true
+    }
+    return isIdempotent(r.Method) || p.conditions()&NextUpstreamNonIdempotent != 0
+}
+
+// failedError returns true if err of request r is failure of server, that is
+// it matches policy conditions like nginx max_fails. Errors caused by client
+// which has gone or by proxy itself aren't failures.
+func (p NextUpstreamPolicy) failedError(r *http.Request, err error) bool {
+    if r.Context().Err() != nil {
+        // client has gone
+        return false
+    }
+    if errors.Is(err, InternalServerError) || errors.Is(err, NotImplementedError) {
+        // request wasn't sent to server
+        return false
+    }
+
+    c := p.conditions()
+    if !isDialError(err) && isTimeout(err) {
+        return c&NextUpstreamTimeout != 0
+    }
+    return c&NextUpstreamError != 0
+}
+
+// retryStatus returns true if request r answered with status can be passed
+// to next server.
+func (p NextUpstreamPolicy) retryStatus(r *http.Request, status int) bool {
+    if !isIdempotent(r.Method) && p.conditions()&NextUpstreamNonIdempotent == 0 {
+        return false
+    }
+    return p.failedStatus(status)
+}
+
+// failedStatus returns true if response status is failure of server, that is
+// it matches policy conditions.
+func (p NextUpstreamPolicy) failedStatus(status int) bool {
+    c := p.conditions()
+    switch status {
+    case http.StatusBadGateway:
+        return c&NextUpstreamHTTP502 != 0
+    case http.StatusServiceUnavailable:
+        return c&NextUpstreamHTTP503 != 0
+    case http.StatusGatewayTimeout:
+        return c&NextUpstreamHTTP504 != 0
+    }
+    return false
+}
+
+// isIdempotent returns false for methods nginx treats as non-idempotent.
+func isIdempotent(method string) bool {
+    switch method {
+    case http.MethodPost, http.MethodPatch, "LOCK":
+        return false
+    }
+    return true
+}
+
+// isDialError returns true if err happened while connecting to server.
+func isDialError(err error) bool {
+    var opErr *net.OpError
+    return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isTimeout returns true if err is caused by timeout.
+func isTimeout(err error) bool {
+    if errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var netErr net.Error
+    return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,316 @@
+package proxy
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestNextUpstreamPolicy(t *testing.T) {
+    get, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+    post, _ := http.NewRequest("POST", "http://127.0.0.1/", nil)
+    dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+    readErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+
+    t.Run("more", func (t *testing.T) {
+        p := NextUpstreamPolicy{Tries: 2}
+        if !p.more(1, time.Now()) {
+            t.Errorf("more after 1 try is false; want true")
+        }
+        if p.more(2, time.Now()) {
+            t.Errorf("more after 2 tries is true; want false")
+        }
+
+        p = NextUpstreamPolicy{Timeout: time.Second}
+        if p.more(1, time.Now().Add(-time.Second)) {
+            t.Errorf("more after timeout is true; want false")
+        }
+
+        p = NextUpstreamPolicy{Conditions: NextUpstreamOff}
+        if p.more(1, time.Now()) {
+            t.Errorf("more with NextUpstreamOff is true; want false")
+        }
+    })
+
+    t.Run("retryError", func (t *testing.T) {
+        cases := []struct{
+            name   string
+            policy NextUpstreamPolicy
+            r      *http.Request
+            err    error
+            want   bool
+        }{
+            {"Dial", NextUpstreamPolicy{}, get, dialErr, true},
+            {"DialPost", NextUpstreamPolicy{}, post, dialErr, true},
+            {"Read", NextUpstreamPolicy{}, get, readErr, true},
+            {"ReadPost", NextUpstreamPolicy{}, post, readErr, false},
+            {"ReadPostAllowed", NextUpstreamPolicy{Conditions: NextUpstreamError|NextUpstreamNonIdempotent}, post, readErr, true},
+            {"Timeout", NextUpstreamPolicy{}, get, context.DeadlineExceeded, true},
+            {"TimeoutDisabled", NextUpstreamPolicy{Conditions: NextUpstreamError}, get, context.DeadlineExceeded, false},
+        }
+
+        for _, c := range cases {
+            if got := c.policy.retryError(c.r, c.err); got != c.want {
+                t.Errorf("%s: retryError is %t; want %t", c.name, got, c.want)
+            }
+        }
+    })
+
+    t.Run("failed", func (t *testing.T) {
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        gone := get.WithContext(ctx)
+        cases := []struct{
+            name   string
+            policy NextUpstreamPolicy
+            r      *http.Request
+            err    error
+            want   bool
+        }{
+            {"Read", NextUpstreamPolicy{}, get, readErr, true},
+            {"ReadPost", NextUpstreamPolicy{}, post, readErr, true},
+            {"Timeout", NextUpstreamPolicy{Conditions: NextUpstreamError}, get, context.DeadlineExceeded, false},
+            {"Off", NextUpstreamPolicy{Conditions: NextUpstreamOff}, get, dialErr, false},
+            {"ClientGone", NextUpstreamPolicy{}, gone, readErr, false},
+            {"Internal", NextUpstreamPolicy{}, get, fmt.Errorf("bad request: %w", InternalServerError), false},
+            {"NotImplemented", NextUpstreamPolicy{}, get, fmt.Errorf("upgrade: %w", NotImplementedError), false},
+        }
+
+        for _, c := range cases {
+            if got := c.policy.failedError(c.r, c.err); got != c.want {
+                t.Errorf("%s: failedError is %t; want %t", c.name, got, c.want)
+            }
+        }
+
+        p := NextUpstreamPolicy{}
+        for _, status := range []int{500, 502, 503, 504} {
+            if p.failedStatus(status) {
+                t.Errorf("status %d is failure of default policy", status)
+            }
+        }
+        p = NextUpstreamPolicy{Conditions: NextUpstreamHTTP503}
+        if !p.failedStatus(503) {
+            t.Errorf("status %d isn't failure of policy", 503)
+        }
+    })
+
+    t.Run("retryStatus", func (t *testing.T) {
+        p := NextUpstreamPolicy{Conditions: NextUpstreamHTTP502|NextUpstreamHTTP504}
+        cases := []struct{
+            r      *http.Request
+            status int
+            want   bool
+        }{
+            {get, 502, true},
+            {get, 503, false},
+            {get, 504, true},
+            {get, 500, false},
+            {post, 502, false},
+        }
+
+        for _, c := range cases {
+            if got := p.retryStatus(c.r, c.status); got != c.want {
+                t.Errorf("%s %d: retryStatus is %t; want %t", c.r.Method, c.status, got, c.want)
+            }
+        }
+    })
+}
+
+// startCountingBackends starts backends responding with statuses and returns
+// upstream servers and requests counters.
+func startCountingBackends(t *testing.T, statuses ...int) ([]*UpstreamServer, []*atomic.Int32) {
+    servers := []*UpstreamServer{}
+    hits := []*atomic.Int32{}
+    for _, status := range statuses {
+        counter := &atomic.Int32{}
+        status := status
+        backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+            counter.Add(1)
+            w.WriteHeader(status)
+        }))
+        t.Cleanup(backend.Close)
+        servers = append(servers, NewUpstreamServer(backend.URL, 1).SetMaxErrors(0))
+        hits = append(hits, counter)
+    }
+    return servers, hits
+}
+
+func TestProxy_NextUpstream(t *testing.T) {
+    t.Run("Status", func (t *testing.T) {
+        servers, hits := startCountingBackends(t, 502, 502, 200)
+        proxy := NewProxy(NewUpstream(servers, &StrategyRoundRobin{}))
+        proxy.NextUpstream = NextUpstreamPolicy{Conditions: NextUpstreamHTTP502}
+        defer proxy.Stop()
+
+        w := httptest.NewRecorder()
+        r := httptest.NewRequest("GET", "/", nil)
+        proxy.GetHandler().ServeHTTP(w, r)
+
+        if w.Code != 200 {
+            t.Errorf("status is %d; want %d", w.Code, 200)
+        }
+        for i, h := range hits {
+            if h.Load() != 1 {
+                t.Errorf("server %d hits is %d; want %d", i, h.Load(), 1)
+            }
+        }
+    })
+
+    t.Run("Tries", func (t *testing.T) {
+        servers, hits := startCountingBackends(t, 502, 502, 502)
+        proxy := NewProxy(NewUpstream(servers, &StrategyRoundRobin{}))
+        proxy.NextUpstream = NextUpstreamPolicy{Conditions: NextUpstreamHTTP502, Tries: 2}
+        defer proxy.Stop()
+
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+        if w.Code != 502 {
+            t.Errorf("status is %d; want %d", w.Code, 502)
+        }
+        total := hits[0].Load() + hits[1].Load() + hits[2].Load()
+        if total != 2 {
+            t.Errorf("total hits is %d; want %d", total, 2)
+        }
+    })
+
+    t.Run("AllServers", func (t *testing.T) {
+        servers, hits := startCountingBackends(t, 503, 503, 503)
+        proxy := NewProxy(NewUpstream(servers, &StrategyRoundRobin{}))
+        proxy.NextUpstream = NextUpstreamPolicy{Conditions: NextUpstreamHTTP503}
+        defer proxy.Stop()
+
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+        if w.Code != 503 {
+            t.Errorf("status is %d; want %d", w.Code, 503)
+        }
+        for i, h := range hits {
+            if h.Load() != 1 {
+                t.Errorf("server %d hits is %d; want %d", i, h.Load(), 1)
+            }
+        }
+    })
+
+    t.Run("NonIdempotent", func (t *testing.T) {
+        servers, hits := startCountingBackends(t, 502, 200)
+        proxy := NewProxy(NewUpstream(servers, &StrategyRoundRobin{}))
+        proxy.NextUpstream = NextUpstreamPolicy{Conditions: NextUpstreamHTTP502}
+        defer proxy.Stop()
+
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+
+        if w.Code != 502 {
+            t.Errorf("status is %d; want %d", w.Code, 502)
+        }
+        if hits[1].Load() != 0 {
+            t.Errorf("POST passed to next server")
+        }
+    })
+
+    t.Run("ConnectionRefused", func (t *testing.T) {
+        servers, hits := startCountingBackends(t, 200)
+        l, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        dead := NewUpstreamServer("http://" + l.Addr().String(), 1)
+        l.Close()
+
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{dead, servers[0]}, &StrategyRoundRobin{}))
+        defer proxy.Stop()
+
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+
+        if w.Code != 200 {
+            t.Errorf("status is %d; want %d", w.Code, 200)
+        }
+        if hits[0].Load() != 1 {
+            t.Errorf("server hits is %d; want %d", hits[0].Load(), 1)
+        }
+    })
+}
+
+func TestProxy_ServerFailures(t *testing.T) {
+    // backend returns server with default MaxErrors and ErrorsTimeout
+    backend := func (h http.HandlerFunc) *UpstreamServer {
+        b := httptest.NewServer(h)
+        t.Cleanup(b.Close)
+        return NewUpstreamServer(b.URL, 1)
+    }
+    status := func (code int) http.HandlerFunc {
+        return func (w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(code)
+        }
+    }
+
+    t.Run("ClientGone", func (t *testing.T) {
+        started := make(chan struct{})
+        server := backend(func (w http.ResponseWriter, r *http.Request) {
+            close(started)
+            <-r.Context().Done()
+        })
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{}))
+        defer proxy.Stop()
+
+        ctx, cancel := context.WithCancel(context.Background())
+        go func() {
+            <-started
+            cancel()
+        }()
+        r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+        proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), r)
+        if !server.Online() {
+            t.Errorf("server is offline after client has gone")
+        }
+    })
+
+    t.Run("Status", func (t *testing.T) {
+        cases := []struct{
+            code       int
+            conditions NextUpstream
+            online     bool
+        }{
+            {500, 0, true},
+            {502, 0, true},
+            {500, NextUpstreamHTTP502, true},
+            {502, NextUpstreamHTTP502, false},
+        }
+
+        for _, c := range cases {
+            server := backend(status(c.code))
+            proxy := NewProxy(NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{}))
+            proxy.NextUpstream = NextUpstreamPolicy{Conditions: c.conditions}
+            proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+            proxy.Stop()
+            if server.Online() != c.online {
+                t.Errorf("%d with conditions %d: online is %t; want %t", c.code, c.conditions, server.Online(), c.online)
+            }
+        }
+    })
+
+    t.Run("ConnectionRefused", func (t *testing.T) {
+        l, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+            t.Fatal(err)
+        }
+        dead := NewUpstreamServer("http://" + l.Addr().String(), 1)
+        l.Close()
+
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{dead}, &StrategyRoundRobin{}))
+        defer proxy.Stop()
+        proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+        if dead.Online() {
+            t.Errorf("refusing server is online")
+        }
+    })
+}
@@ -4,17 +4,10 @@ import (
     "fmt"
     "net/http"
     "log"
-    "io"
-    "strings"
-    "errors"
+    "sync"
     "time"
 )
 
-var InternalServerError error = errors.New("internal server error")
-var GatewayTimeoutError error = errors.New("gateway timeout")
-var BadGatewayError error = errors.New("bad gateway")
-var ServiceUnavailableError error = errors.New("service unavailable")
-
 // ProxyHandler is function running before or after proxy request
 type ProxyHandler func(next http.Handler) http.Handler
 
@@ -32,8 +25,8 @@ type Proxy struct {
     // started stores started flag. prosy marked started on first request.
     started        bool
 
-    // stop channel used to stop timers when proxy stops.
-    stop           chan struct{}
+    // stopped is set by Stop, stopped proxy isn't started by requests.
+    stopped        bool
 
     // beforeHandlers stores handlers running before proxyin
     beforeHandlers []ProxyHandler
@@ -41,46 +34,96 @@ type Proxy struct {
     // afterHandlers stores handlers running after proxying
     afterHandlers  []ProxyHandler
 
-    // tickers stores time tickers for errors checks
-    tickers map[string]*time.Ticker
+    // NextUpstream defines when failed request is passed to next server.
+    NextUpstream   NextUpstreamPolicy
+
+    // RequestBuffering defines how request body is buffered. Request with
+    // unbuffered body is never passed to next server. Nil disables
+    // buffering.
+    RequestBuffering *RequestBuffering
+
+    // Forwarding defines headers passing client information to upstream
+    // servers. Nil disables forwarding headers.
+    Forwarding     *Forwarding
+
+    // FlushInterval is interval of flushing response body to client. Zero
+    // disables periodic flushing, negative value means flush after every
+    // write. Server-Sent Events and responses with unknown length are
+    // flushed immediately.
+    FlushInterval  time.Duration
+
+    // UpgradeIdleTimeout closes upgraded connections, for example
+    // WebSocket, without traffic during this time. Zero means no limit.
+    UpgradeIdleTimeout time.Duration
+
+    // UpgradeMaxLifetime closes upgraded connections after this time.
+    // Zero means no limit.
+    UpgradeMaxLifetime time.Duration
+
+    // ErrorHandler renders error response when request can't be proxied.
+    // If nil, DefaultErrorHandler is used.
+    ErrorHandler   ErrorHandler
+
+    mux            sync.Mutex
 }
 
 // NewProxy returns a new Proxy with configured upstream
 func NewProxy(upstream *Upstream) *Proxy {
     return &Proxy{
         upstream: upstream,
-        tickers: make(map[string]*time.Ticker),
     }
 }
 
+// Start starts upstream background goroutines, which return servers taken
+// offline by errors back online and run active health checks. If Start was
+// not called, proxy starts on first request, but proxy stopped by Stop
+// starts only by Start. Repeated calls do nothing.
 func (p *Proxy) Start() {
-    p.started = true
+    p.mux.Lock()
+    defer p.mux.Unlock()
+    p.stopped = false
+    p.start()
+}
 
-    /**
-    // TODO: where to place ticker
-    ticker := time.Ticker(time.Second * errorsTimeout)
-    server.ticker = ticker
-    go func(){
-        // TODO: stop timers on proxy stop
-        select {
-        case < t.C:
-            server.mux.Lock()
-            if server.errors >= server.maxErrors {
-                server.online = false
-            } else {
-                server.online = true
-            }
-            server.errors = 0
-            server.mux.Unlock()
-        case <-server.stop:
+// startLazily starts proxy on request unless it was stopped.
+func (p *Proxy) startLazily() {
+    p.mux.Lock()
+    defer p.mux.Unlock()
+    if !p.stopped {
+        p.start()
+    }
+}
 
-        }
-    }()
-    */
+// start starts upstream goroutines. Caller must hold p.mux.
+func (p *Proxy) start() {
+    if p.started {
+        return
+    }
+    p.started = true
+    p.upstream.StartTimers()
+    p.upstream.StartHealthChecks()
 }
 
+// Stop stops goroutines started by Start, waits for them and closes idle
+// connections to upstream servers.
 func (p *Proxy) Stop() {
+    p.mux.Lock()
+    defer p.mux.Unlock()
+    p.stopped = true
+    if !p.started {
+        return
+    }
     p.started = false
+    p.upstream.StopHealthChecks()
+    p.upstream.StopTimers()
+    p.upstream.closeIdleConnections()
+}
+
+// Started reports whether proxy is started.
+func (p *Proxy) Started() bool {
+    p.mux.Lock()
+    defer p.mux.Unlock()
+    return p.started
 }
 
 // RegisterBeforeHandler adds ProxyHandler into handlers chain
@@ -133,30 +176,147 @@ func (p *Proxy) logf(format string, args ...interface{}) {
 // GetProxyHandler returns only proxy handler without middleware handlers.
 func (p *Proxy) GetProxyHandler(next http.Handler) http.Handler {
     return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
-        complete := false
-        for {
-            server, err := p.upstream.next(r)
-            if err != nil {
-                p.logf("proxy: upstream [%s] : %v", server, err)
-                http.Error(w, "Service Unavailable", 503)
-                return
+        if !p.Started() {
+            p.startLazily()
+        }
+
+        if p.serve(w, r) {
+            next.ServeHTTP(w, r)
+        }
+    })
+}
+
+// serve passes request to upstream servers according to NextUpstream policy
+// and writes response. It returns true if upstream response is written.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) bool {
+    start := time.Now()
+    tried := make(triedServers)
+    ur := withTried(r, tried)
+
+    if isUpgrade(r.Header) && !canHijack(w) {
+        err := fmt.Errorf("can't upgrade %s connection: %w", r.Proto, NotImplementedError)
+        p.logf("proxy: %v", err)
+        p.handleError(w, r, nil, err)
+        return false
+    }
+
+    body, err := bufferBody(r, p.RequestBuffering)
+    if err != nil {
+        p.logf("proxy: can't read request body: %v", err)
+        p.handleError(w, r, nil, err)
+        return false
+    }
+    defer body.Close()
+    policy := p.NextUpstream
+    if !body.replayable() {
+        policy.Conditions = NextUpstreamOff
+    }
+
+    var res *http.Response
+    var server *UpstreamServer
+    var lastErr error
+    for tries := uint(1); ; tries++ {
+        srv, err := p.upstream.next(ur)
+        if err != nil {
+            if res != nil {
+                // pass last response to client
+                break
+            }
+            if lastErr != nil {
+                p.handleError(w, r, server, lastErr)
+                return false
             }
-            server.incrConnections()
-            err = proxyRequest(server, w, r)
+            err = fmt.Errorf("%w: %w", err, ServiceUnavailableError)
+            p.logf("proxy: upstream : %v", err)
+            p.handleError(w, r, nil, err)
+            return false
+        }
+
+        tried[srv] = true
+        if !srv.acquire() {
+            // server started draining after it was chosen
+            tries--
+            continue
+        }
+
+        if res != nil {
+            res.Body.Close()
             server.decrConnections()
-            if err != nil {
-                server.incrErrors()
-                p.logf("proxy: upstream [%s] : %v", server, err)
-            } else {
-                complete = true
-                break
+            res = nil
+        }
+
+        server = srv
+        res, err = p.proxyRequest(server, r, body)
+        if err != nil {
+            server.decrConnections()
+            // failures are counted by configured policy even if request
+            // body can't be passed to next server
+            if p.NextUpstream.failedError(r, err) {
+                p.upstream.failed(server)
+            }
+            p.logf("proxy: upstream [%s] : %v", server, err)
+            lastErr = err
+            if policy.retryError(r, err) && policy.more(tries, start) {
+                continue
             }
+            if r.Context().Err() == nil {
+                p.handleError(w, r, server, err)
+            }
+            return false
         }
 
-        if complete {
-            next.ServeHTTP(w, r)
+        if p.NextUpstream.failedStatus(res.StatusCode) {
+            p.upstream.failed(server)
         }
-    })
+        if !policy.retryStatus(r, res.StatusCode) || !policy.more(tries, start) {
+            break
+        }
+        p.logf("proxy: upstream [%s] : response status %d", server, res.StatusCode)
+    }
+
+    defer server.decrConnections()
+    defer res.Body.Close()
+    p.upstream.modifyResponse(r, server, res)
+    if res.StatusCode == http.StatusSwitchingProtocols {
+        if err := checkUpgrade(r, res); err != nil {
+            p.logf("proxy: upstream [%s] : %v", server, err)
+            p.handleError(w, r, server, err)
+            return false
+        }
+        conn, brw, err := hijack(w)
+        if err != nil {
+            p.logf("proxy: upstream [%s] : %v", server, err)
+            p.handleError(w, r, server, err)
+            return false
+        }
+        if err := p.tunnel(conn, brw, res); err != nil {
+            p.logf("proxy: upstream [%s] : %v", server, err)
+        }
+        return true
+    }
+
+    if err := p.writeResponse(w, res); err != nil {
+        p.logf("proxy: upstream [%s] : %v", server, err)
+        return false
+    }
+
+    return true
+}
+
+// handleError renders error response for classified error using
+// ErrorHandler.
+func (p *Proxy) handleError(w http.ResponseWriter, r *http.Request, server *UpstreamServer, err error) {
+    perr := &ProxyError{
+        Status: errorStatus(err),
+        Server: server,
+        Err: err,
+    }
+
+    h := p.ErrorHandler
+    if h == nil {
+        h = DefaultErrorHandler
+    }
+    h(w, r, server, perr)
 }
 
 // finalHandler returns empty http.Handler used in handlers chain generation.
@@ -165,38 +325,71 @@ func finalHandler() http.Handler {
     })
 }
 
-// copyHeaders copying headers between http.Header instances.
-func copyHeaders(src, dst http.Header) {
-    for k, v := range src {
-        dst.Set(k, strings.Join(v, ","))
+// proxyRequest sends Request with body to specified Server and returns its
+// response. At this time it's don't intercept errors returned from backend.
+// Server's latency is recorded when response headers and body are received,
+// failed and 5xx responses are recorded with latency penalty.
+func (p *Proxy) proxyRequest(server *UpstreamServer, r *http.Request, body *requestBody) (*http.Response, error) {
+    if isUpgrade(r.Header) && server.Protocol() != ProtocolHTTP1 {
+        // HTTP/2 has no Upgrade mechanism
+        return nil, fmt.Errorf("can't upgrade connection to %s server: %w", server.Protocol(), NotImplementedError)
     }
-}
 
-// proxyRequest proxying Request to specified Server.
-// At this time it's don't intercept errors returned from backend.
-func proxyRequest(server *UpstreamServer, w http.ResponseWriter, r *http.Request) error {
-    url := fmt.Sprintf("%s/%s", server.String(), r.RequestURI)
-    preq, err := http.NewRequestWithContext(r.Context(), r.Method, url, r.Body)
+    target := targetURL(server, r.URL)
+    preq, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), body.reader())
     if err != nil {
-        return fmt.Errorf("%v: %w", err, InternalServerError)
+        return nil, fmt.Errorf("%v: %w", err, InternalServerError)
+    }
+    preq.URL = target
+    preq.Host = p.upstream.requestHost(r)
+    preq.ContentLength = body.contentLength()
+    preq.Header = requestHeaders(r)
+    if isUpgrade(r.Header) {
+        preq.Header.Set("Connection", "Upgrade")
+        preq.Header.Set("Upgrade", r.Header.Get("Upgrade"))
     }
-    copyHeaders(r.Header, preq.Header)
-    pres, err := http.DefaultClient.Do(preq)
+    if p.Forwarding != nil {
+        p.Forwarding.apply(r, preq.Header)
+    }
+    start := p.upstream.now()
+    pres, err := p.upstream.roundTrip(server, preq)
+    now := p.upstream.now()
     if err != nil {
-        return fmt.Errorf("%v: %w", err, GatewayTimeoutError)
+        if r.Context().Err() == nil {
+            server.observeFailure(now, now.Sub(start))
+        }
+        return nil, classifyError(err)
     }
-    defer pres.Body.Close()
-
     if pres.StatusCode >= 500 {
-        server.incrErrors()
+        server.observeFailure(now, now.Sub(start))
+        return pres, nil
     }
 
-    copyHeaders(pres.Header, w.Header())
+    server.observeTTFB(now, now.Sub(start))
+    if pres.StatusCode != http.StatusSwitchingProtocols {
+        pres.Body = &latencyBody{
+            ReadCloser: pres.Body,
+            ctx: r.Context(),
+            server: server,
+            now: p.upstream.now,
+            start: start,
+        }
+    }
+
+    return pres, nil
+}
+
+// writeResponse copies upstream response without hop-by-hop headers to
+// client. Body is flushed according to FlushInterval, response trailers are
+// sent after body.
+func (p *Proxy) writeResponse(w http.ResponseWriter, pres *http.Response) error {
+    announced := len(pres.Trailer)
+    responseHeaders(pres, w.Header())
     w.WriteHeader(pres.StatusCode)
-    _, err = io.Copy(w, pres.Body)
-    if err != nil {
-        return fmt.Errorf("%v: %w", err, BadGatewayError)
+    if err := copyResponse(w, pres.Body, p.flushInterval(pres)); err != nil {
+        return err
     }
 
+    responseTrailers(pres, announced, w.Header())
     return nil
 }
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+    "errors"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// HostPolicy defines Host header of requests passed to upstream servers.
+type HostPolicy int
+
+const (
+    // HostUpstream sets Host header to server's host:port. It's default.
+    HostUpstream HostPolicy = iota
+
+    // HostClient passes Host header received from client.
+    HostClient
+
+    // HostFixed sets Host header to UpstreamOptions.Host.
+    HostFixed
+)
+
+// validateHost returns error if host policy is invalid.
+func validateHost(policy HostPolicy, host string) error {
+    switch policy {
+    case HostUpstream, HostClient:
+        return nil
+    case HostFixed:
+        if host == "" {
+            return errors.New("fixed host policy requires host")
+        }
+        return nil
+    }
+    return errors.New("unknown host policy")
+}
+
+// targetURL returns URL of request with URL u on server. Server's path is
+// prepended to request path, escaping and query string are kept.
+func targetURL(server *UpstreamServer, u *url.URL) *url.URL {
+    target := &url.URL{
+        Scheme: server.Proto(),
+        Host: server.addr(),
+        Path: server.Path(),
+    }
+    target.Path, target.RawPath = joinURLPath(target, u)
+    target.RawQuery = u.RawQuery
+    target.ForceQuery = u.ForceQuery
+    return target
+}
+
+// joinURLPath joins paths of base and u with single slash, escaped path is
+// joined too if one of paths is escaped differently from default.
+func joinURLPath(base, u *url.URL) (path, rawpath string) {
+    if base.Path == "" {
+        return u.Path, u.RawPath
+    }
+    if u.Path == "" {
+        return base.Path, base.RawPath
+    }
+
+    if base.RawPath == "" && u.RawPath == "" {
+        return singleJoiningSlash(base.Path, u.Path), ""
+    }
+
+    bpath := base.EscapedPath()
+    upath := u.EscapedPath()
+    return singleJoiningSlash(base.Path, u.Path), singleJoiningSlash(bpath, upath)
+}
+
+// singleJoiningSlash joins a and b with single slash.
+func singleJoiningSlash(a, b string) string {
+    aslash := strings.HasSuffix(a, "/")
+    bslash := strings.HasPrefix(b, "/")
+    switch {
+    case aslash && bslash:
+        return a + b[1:]
+    case !aslash && !bslash:
+        return a + "/" + b
+    }
+    return a + b
+}
+
+// requestHost returns Host header of request r passed to server according
+// to upstream host policy. Empty string means server's host:port.
+func (u *Upstream) requestHost(r *http.Request) string {
+    switch u.hostPolicy {
+    case HostClient:
+        return r.Host
+    case HostFixed:
+        return u.host
+    }
+    return ""
+}
+
+// checkHost returns Host header of health checks according to upstream host
+// policy. Checks have no client Host, so server's host:port is used for
+// HostClient.
+func (u *Upstream) checkHost() string {
+    if u.hostPolicy == HostFixed {
+        return u.host
+    }
+    return ""
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "testing"
+)
+
+func TestTargetURL(t *testing.T) {
+    cases := []struct{
+        server string
+        uri    string
+        want   string
+    }{
+        {"http://127.0.0.1:8080", "/", "http://127.0.0.1:8080/"},
+        {"http://127.0.0.1:8080", "/a/b?x=1&y=2", "http://127.0.0.1:8080/a/b?x=1&y=2"},
+        {"http://127.0.0.1:8080", "/a%2Fb/c%20d?q=%2F", "http://127.0.0.1:8080/a%2Fb/c%20d?q=%2F"},
+        {"http://127.0.0.1:8080", "/a?", "http://127.0.0.1:8080/a?"},
+        {"https://127.0.0.1:8443/api", "/v1/users", "https://127.0.0.1:8443/api/v1/users"},
+        {"http://127.0.0.1:8080/api/", "/v1/a%2Fb", "http://127.0.0.1:8080/api/v1/a%2Fb"},
+        {"http://[::1]:8080", "/", "http://[::1]:8080/"},
+    }
+
+    for _, c := range cases {
+        server := NewUpstreamServer(c.server, 1)
+        u, err := url.ParseRequestURI(c.uri)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := targetURL(server, u).String(); got != c.want {
+            t.Errorf("%s + %s is '%s'; want '%s'", c.server, c.uri, got, c.want)
+        }
+    }
+}
+
+func TestUpstream_requestHost(t *testing.T) {
+    var got string
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        got = r.Host
+    }))
+    defer backend.Close()
+    server := NewUpstreamServer(backend.URL, 1)
+
+    cases := []struct{
+        name string
+        opts UpstreamOptions
+        want string
+    }{
+        {"Upstream", UpstreamOptions{}, server.addr()},
+        {"Client", UpstreamOptions{HostPolicy: HostClient}, "example.com"},
+        {"Fixed", UpstreamOptions{HostPolicy: HostFixed, Host: "backend.local"}, "backend.local"},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            u, err := NewUpstreamWithOptions([]*UpstreamServer{server}, &StrategyRoundRobin{}, c.opts)
+            if err != nil {
+                t.Fatal(err)
+            }
+            proxy := NewProxy(u)
+            defer proxy.Stop()
+
+            proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+            if got != c.want {
+                t.Errorf("Host is '%s'; want '%s'", got, c.want)
+            }
+        })
+    }
+
+    t.Run("FixedWithoutHost", func (t *testing.T) {
+        _, err := NewUpstreamWithOptions([]*UpstreamServer{server}, &StrategyRoundRobin{}, UpstreamOptions{HostPolicy: HostFixed})
+        if err == nil {
+            t.Errorf("fixed host policy without host accepted")
+        }
+    })
+}
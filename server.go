@@ -0,0 +1,207 @@
+package proxy
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// A Server serves proxy over HTTPS with certificates chosen by SNI and
+// optionally redirects HTTP requests to HTTPS.
+type Server struct {
+    // Addr is HTTPS address to listen on. Default is ":https".
+    Addr string
+
+    // RedirectAddr is HTTP address to listen on for redirects to HTTPS.
+    // Empty address disables redirects.
+    RedirectAddr string
+
+    // ReloadInterval is interval of checking certificates files for
+    // changes. Default is 1 minute, negative value disables reloading.
+    ReloadInterval time.Duration
+
+    // TLSConfig is optional TLS configuration, its certificates are
+    // replaced with certificates of the store.
+    TLSConfig *tls.Config
+
+    proxy *Proxy
+    certs *CertificateStore
+
+    servers []*http.Server
+    closed  bool
+    stop    chan struct{}
+    wg      sync.WaitGroup
+
+    mux sync.Mutex
+}
+
+// NewServer returns Server serving proxy with certificates of store certs.
+func NewServer(proxy *Proxy, certs *CertificateStore) *Server {
+    return &Server{
+        proxy: proxy,
+        certs: certs,
+    }
+}
+
+// ListenAndServe listens on Addr and RedirectAddr and serves requests.
+// It always returns non-nil error, after Shutdown it's http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+    addr := s.Addr
+    if addr == "" {
+        addr = ":https"
+    }
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+
+    if s.RedirectAddr != "" {
+        rln, err := net.Listen("tcp", s.RedirectAddr)
+        if err != nil {
+            ln.Close()
+            return err
+        }
+        go func() {
+            if err := s.ServeRedirect(rln); err != nil && err != http.ErrServerClosed {
+                s.proxy.logf("redirect server failed: %v", err)
+            }
+        }()
+    }
+    return s.Serve(ln)
+}
+
+// Serve accepts TLS connections on listener ln and proxies requests. It
+// starts proxy and reloading of certificates.
+func (s *Server) Serve(ln net.Listener) error {
+    config := &tls.Config{}
+    if s.TLSConfig != nil {
+        config = s.TLSConfig.Clone()
+    }
+    config.Certificates = nil
+    config.GetCertificate = s.certs.GetCertificate
+
+    srv := &http.Server{
+        Handler: s.proxy.GetHandler(),
+        TLSConfig: config,
+        ErrorLog: s.proxy.ErrorLog,
+    }
+    if err := s.track(srv); err != nil {
+        ln.Close()
+        return err
+    }
+    s.proxy.Start()
+    s.startReloading()
+    return srv.ServeTLS(ln, "", "")
+}
+
+// ServeRedirect accepts connections on listener ln and redirects requests
+// to HTTPS.
+func (s *Server) ServeRedirect(ln net.Listener) error {
+    srv := &http.Server{
+        Handler: s.redirectHandler(),
+        ErrorLog: s.proxy.ErrorLog,
+    }
+    if err := s.track(srv); err != nil {
+        ln.Close()
+        return err
+    }
+    return srv.Serve(ln)
+}
+
+// Shutdown gracefully stops servers, reloading of certificates and proxy.
+func (s *Server) Shutdown(ctx context.Context) error {
+    s.mux.Lock()
+    s.closed = true
+    servers := s.servers
+    s.servers = nil
+    stop := s.stop
+    s.stop = nil
+    s.mux.Unlock()
+
+    if stop != nil {
+        close(stop)
+        s.wg.Wait()
+    }
+
+    var errs []error
+    for _, srv := range servers {
+        errs = append(errs, srv.Shutdown(ctx))
+    }
+    s.proxy.Stop()
+    return errors.Join(errs...)
+}
+
+// track registers srv for shutdown.
+func (s *Server) track(srv *http.Server) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    if s.closed {
+        return http.ErrServerClosed
+    }
+    s.servers = append(s.servers, srv)
+    return nil
+}
+
+// startReloading starts goroutine reloading certificates every
+// ReloadInterval. Repeated calls do nothing.
+func (s *Server) startReloading() {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    if s.stop != nil || s.closed || s.ReloadInterval < 0 {
+        return
+    }
+    interval := s.ReloadInterval
+    if interval == 0 {
+        interval = time.Minute
+    }
+
+    stop := make(chan struct{})
+    s.stop = stop
+    s.wg.Add(1)
+    go func() {
+        defer s.wg.Done()
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                if err := s.certs.Reload(); err != nil {
+                    s.proxy.logf("can't reload certificates: %v", err)
+                }
+            case <-stop:
+                return
+            }
+        }
+    }()
+}
+
+// redirectHandler returns handler redirecting requests to the same URL
+// with https scheme and port of Addr.
+func (s *Server) redirectHandler() http.Handler {
+    port := ""
+    if _, p, err := net.SplitHostPort(s.Addr); err == nil && p != "443" && p != "https" {
+        port = p
+    }
+
+    return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        }
+        if port != "" {
+            host = net.JoinHostPort(host, port)
+        } else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+            host = "[" + host + "]"
+        }
+
+        status := http.StatusPermanentRedirect
+        if r.Method == http.MethodGet || r.Method == http.MethodHead {
+            status = http.StatusMovedPermanently
+        }
+        http.Redirect(w, r, "https://" + host + r.URL.RequestURI(), status)
+    })
+}
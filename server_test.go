@@ -0,0 +1,158 @@
+package proxy
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+    "time"
+)
+
+func TestServer(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        io.WriteString(w, "backend")
+    }))
+    defer backend.Close()
+
+    dir := t.TempDir()
+    certFile, keyFile := writeTestCertificate(t, dir, "example.com")
+    store := NewCertificateStore()
+    if err := store.Add(certFile, keyFile); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Add(writeTestCertificate(t, dir, "other.test")); err != nil {
+        t.Fatal(err)
+    }
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(backend.URL, 1)}, &StrategyRoundRobin{}))
+    server := NewServer(proxy, store)
+    server.Addr = ln.Addr().String()
+    server.ReloadInterval = time.Millisecond * 10
+    go server.Serve(ln)
+    go server.ServeRedirect(rln)
+    defer server.Shutdown(context.Background())
+
+    // dial returns TLS connection to server with SNI name.
+    dial := func (name string) *tls.Conn {
+        conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        return conn
+    }
+
+    t.Run("SNI", func (t *testing.T) {
+        for _, name := range []string{"example.com", "other.test"} {
+            conn := dial(name)
+            cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+            conn.Close()
+            if cn != name {
+                t.Errorf("certificate for '%s' is '%s'", name, cn)
+            }
+        }
+    })
+
+    t.Run("Proxy", func (t *testing.T) {
+        client := &http.Client{Transport: &http.Transport{
+            TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+        }}
+        res, err := client.Get("https://" + ln.Addr().String() + "/")
+        if err != nil {
+            t.Fatal(err)
+        }
+        body, _ := io.ReadAll(res.Body)
+        res.Body.Close()
+        if string(body) != "backend" {
+            t.Errorf("body is '%s'; want '%s'", body, "backend")
+        }
+    })
+
+    t.Run("Reload", func (t *testing.T) {
+        conn := dial("example.com")
+        defer conn.Close()
+        old := conn.ConnectionState().PeerCertificates[0]
+
+        writeTestCertificate(t, dir, "example.com")
+        future := time.Now().Add(time.Minute)
+        os.Chtimes(certFile, future, future)
+
+        var cert *x509.Certificate
+        deadline := time.Now().Add(time.Second * 5)
+        for time.Now().Before(deadline) {
+            c := dial("example.com")
+            cert = c.ConnectionState().PeerCertificates[0]
+            c.Close()
+            if !cert.Equal(old) {
+                break
+            }
+            time.Sleep(time.Millisecond * 10)
+        }
+        if cert.Equal(old) {
+            t.Fatalf("certificate is not reloaded")
+        }
+
+        // connection established before reload still works
+        io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+        conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+        buf := make([]byte, 12)
+        if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "HTTP/1.1 200" {
+            t.Errorf("old connection response is '%s' (%v); want '%s'", buf, err, "HTTP/1.1 200")
+        }
+    })
+
+    t.Run("Redirect", func (t *testing.T) {
+        client := &http.Client{CheckRedirect: func (*http.Request, []*http.Request) error {
+            return http.ErrUseLastResponse
+        }}
+        _, port, _ := net.SplitHostPort(ln.Addr().String())
+        cases := []struct{
+            method string
+            status int
+        }{
+            {"GET", http.StatusMovedPermanently},
+            {"POST", http.StatusPermanentRedirect},
+        }
+        for _, c := range cases {
+            req, _ := http.NewRequest(c.method, "http://" + rln.Addr().String() + "/path?q=1", nil)
+            req.Host = "example.com"
+            res, err := client.Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            res.Body.Close()
+            if res.StatusCode != c.status {
+                t.Errorf("%s status is %d; want %d", c.method, res.StatusCode, c.status)
+            }
+            want := "https://example.com:" + port + "/path?q=1"
+            if loc := res.Header.Get("Location"); loc != want {
+                t.Errorf("%s location is '%s'; want '%s'", c.method, loc, want)
+            }
+        }
+    })
+
+    t.Run("Shutdown", func (t *testing.T) {
+        if err := server.Shutdown(context.Background()); err != nil {
+            t.Fatal(err)
+        }
+        if proxy.Started() {
+            t.Errorf("proxy is started after shutdown")
+        }
+        if err := server.Serve(ln); err != http.ErrServerClosed {
+            t.Errorf("serve error after shutdown is '%v'; want '%v'", err, http.ErrServerClosed)
+        }
+    })
+}
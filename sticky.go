@@ -0,0 +1,209 @@
+package proxy
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// defaultStickyCookie is default StrategySticky cookie name.
+const defaultStickyCookie = "route"
+
+// A StrategySticky realises UpstreamStrategy. It binds client to server by
+// cookie like nginx sticky cookie: first response sets cookie naming server
+// chosen by wrapped Strategy, later requests with cookie are passed to the
+// same server while it's available. Otherwise wrapped Strategy chooses new
+// server and cookie is replaced. Cookie isn't available to scripts.
+type StrategySticky struct {
+    // Strategy chooses server for requests without valid cookie. It's
+    // required.
+    Strategy UpstreamStrategy
+
+    // Cookie is cookie name, default is "route".
+    Cookie string
+
+    // Path is cookie path, default is "/".
+    Path string
+
+    // TTL is cookie lifetime. Zero means cookie lives until browser is
+    // closed.
+    TTL time.Duration
+
+    // Secure makes cookie be sent only over HTTPS.
+    Secure bool
+
+    // SameSite is cookie SameSite attribute, by default it's not sent.
+    SameSite http.SameSite
+
+    // Key signs cookie by HMAC-SHA256, so clients can't forge it. Signed
+    // cookie holds its issue time and is rejected after TTL. Without Key
+    // cookie holds opaque server ID.
+    Key []byte
+
+    // Clock is source of time of signed cookies. Default is system clock.
+    Clock Clock
+
+    // ids maps servers IDs to servers and back.
+    ids     map[string]*UpstreamServer
+    servers map[*UpstreamServer]string
+    mux     sync.Mutex
+}
+
+// SetServers sets servers of wrapped strategy and their IDs.
+// Method is safe for concurrent access.
+func (s *StrategySticky) SetServers(servers []*UpstreamServer) error {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    if s.Strategy == nil {
+        return errors.New("sticky strategy requires Strategy")
+    }
+    if err := s.Strategy.SetServers(servers); err != nil {
+        return err
+    }
+
+    ids := make(map[string]*UpstreamServer, len(servers))
+    names := make(map[*UpstreamServer]string, len(servers))
+    for _, srv := range servers {
+        id := stickyID(srv)
+        ids[id] = srv
+        names[srv] = id
+    }
+    s.ids = ids
+    s.servers = names
+    return nil
+}
+
+// stickyID returns opaque ID of server, it's the same for the same server
+// address.
+func stickyID(srv *UpstreamServer) string {
+    sum := sha256.Sum256([]byte(srv.String()))
+    return hex.EncodeToString(sum[:8])
+}
+
+// Next returns server named by request cookie if it's available, or server
+// chosen by wrapped strategy.
+// Method is safe for concurrent access.
+func (s *StrategySticky) Next(r *http.Request) (*UpstreamServer, error) {
+    s.mux.Lock()
+    strategy := s.Strategy
+    srv := s.cookieServer(r)
+    s.mux.Unlock()
+
+    if strategy == nil {
+        return nil, errors.New("sticky strategy requires Strategy")
+    }
+    if srv != nil && available(r, srv) {
+        return srv, nil
+    }
+    return strategy.Next(r)
+}
+
+// cookieServer returns server named by valid request cookie or nil.
+// Caller must hold s.mux.
+func (s *StrategySticky) cookieServer(r *http.Request) *UpstreamServer {
+    c, err := r.Cookie(s.cookieName())
+    if err != nil {
+        return nil
+    }
+    id := c.Value
+    if s.Key != nil {
+        // signed cookie is id.issued.signature
+        i := strings.LastIndexByte(id, '.')
+        if i < 0 || !hmac.Equal([]byte(id[i+1:]), []byte(s.sign(id[:i]))) {
+            return nil
+        }
+        payload, issued, ok := strings.Cut(id[:i], ".")
+        if !ok || s.expired(issued) {
+            return nil
+        }
+        id = payload
+    }
+    return s.ids[id]
+}
+
+// expired returns true if signed cookie issued at unix time issued has
+// outlived TTL.
+func (s *StrategySticky) expired(issued string) bool {
+    sec, err := strconv.ParseInt(issued, 10, 64)
+    if err != nil {
+        return true
+    }
+    if s.TTL <= 0 {
+        return false
+    }
+    return clockNow(s.Clock).Sub(time.Unix(sec, 0)) > s.TTL
+}
+
+// sign returns HMAC signature of payload.
+func (s *StrategySticky) sign(payload string) string {
+    mac := hmac.New(sha256.New, s.Key)
+    mac.Write([]byte(payload))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieName returns name of cookie.
+func (s *StrategySticky) cookieName() string {
+    if s.Cookie == "" {
+        return defaultStickyCookie
+    }
+    return s.Cookie
+}
+
+// ModifyResponse sets cookie naming server if request has no cookie of
+// the server. Wrapped ResponseStrategy modifies response too.
+// Method is safe for concurrent access.
+func (s *StrategySticky) ModifyResponse(r *http.Request, server *UpstreamServer, res *http.Response) {
+    s.mux.Lock()
+    strategy := s.Strategy
+    current := s.cookieServer(r)
+    id, ok := s.servers[server]
+    var cookie *http.Cookie
+    if ok && current != server {
+        cookie = s.cookie(id)
+    }
+    s.mux.Unlock()
+
+    if cookie != nil {
+        res.Header.Add("Set-Cookie", cookie.String())
+    }
+    if rs, ok := strategy.(ResponseStrategy); ok {
+        rs.ModifyResponse(r, server, res)
+    }
+}
+
+// cookie returns cookie naming server with id.
+// Caller must hold s.mux.
+func (s *StrategySticky) cookie(id string) *http.Cookie {
+    value := id
+    if s.Key != nil {
+        payload := id + "." + strconv.FormatInt(clockNow(s.Clock).Unix(), 10)
+        value = payload + "." + s.sign(payload)
+    }
+    path := s.Path
+    if path == "" {
+        path = "/"
+    }
+    c := &http.Cookie{
+        Name: s.cookieName(),
+        Value: value,
+        Path: path,
+        Secure: s.Secure,
+        HttpOnly: true,
+        SameSite: s.SameSite,
+    }
+    if s.TTL > 0 {
+        c.MaxAge = int(s.TTL / time.Second)
+        if c.MaxAge == 0 {
+            c.MaxAge = 1
+        }
+    }
+    return c
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+// stickyResponse returns response of server to request r modified by
+// strategy and cookie set by it.
+func stickyResponse(strategy *StrategySticky, r *http.Request, server *UpstreamServer) *http.Cookie {
+    res := &http.Response{Header: make(http.Header)}
+    strategy.ModifyResponse(r, server, res)
+    for _, c := range res.Cookies() {
+        if c.Name == strategy.cookieName() {
+            return c
+        }
+    }
+    return nil
+}
+
+func TestStrategySticky(t *testing.T) {
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    cluster := []*UpstreamServer{a, b}
+
+    strategy := &StrategySticky{
+        Strategy: &StrategyRoundRobin{},
+        Cookie: "backend",
+        Path: "/app",
+        TTL: time.Hour,
+        Secure: true,
+        SameSite: http.SameSiteLaxMode,
+    }
+    if err := strategy.SetServers(cluster); err != nil {
+        t.Fatal(err)
+    }
+
+    r, _ := http.NewRequest("GET", "http://127.0.0.1/app", nil)
+    first, err := strategy.Next(r)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cookie := stickyResponse(strategy, r, first)
+    if cookie == nil {
+        t.Fatalf("cookie isn't set")
+    }
+
+    t.Run("Attributes", func (t *testing.T) {
+        if cookie.Path != "/app" {
+            t.Errorf("path is '%s'; want '%s'", cookie.Path, "/app")
+        }
+        if cookie.MaxAge != 3600 {
+            t.Errorf("max age is %d; want %d", cookie.MaxAge, 3600)
+        }
+        if !cookie.Secure || !cookie.HttpOnly {
+            t.Errorf("cookie isn't secure or http only")
+        }
+        if cookie.SameSite != http.SameSiteLaxMode {
+            t.Errorf("same site is %d; want %d", cookie.SameSite, http.SameSiteLaxMode)
+        }
+        if strings.Contains(cookie.Value, "8000") || strings.Contains(cookie.Value, "8001") {
+            t.Errorf("cookie '%s' exposes server address", cookie.Value)
+        }
+    })
+
+    t.Run("Sticky", func (t *testing.T) {
+        cr, _ := http.NewRequest("GET", "http://127.0.0.1/app", nil)
+        cr.AddCookie(cookie)
+        for i := 0; i < 10; i++ {
+            if next, _ := strategy.Next(cr); next != first {
+                t.Fatalf("%d] server is '%s'; want '%s'", i, next, first)
+            }
+        }
+        if c := stickyResponse(strategy, cr, first); c != nil {
+            t.Errorf("cookie '%s' is set again", c.Value)
+        }
+    })
+
+    t.Run("Offline", func (t *testing.T) {
+        cr, _ := http.NewRequest("GET", "http://127.0.0.1/app", nil)
+        cr.AddCookie(cookie)
+        first.online = false
+        defer func() { first.online = true }()
+
+        next, err := strategy.Next(cr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if next == first {
+            t.Fatalf("offline server '%s' is returned", next)
+        }
+        c := stickyResponse(strategy, cr, next)
+        if c == nil || c.Value == cookie.Value {
+            t.Errorf("cookie isn't replaced")
+        }
+    })
+
+    t.Run("Unknown", func (t *testing.T) {
+        cr, _ := http.NewRequest("GET", "http://127.0.0.1/app", nil)
+        cr.AddCookie(&http.Cookie{Name: "backend", Value: "unknown"})
+        if _, err := strategy.Next(cr); err != nil {
+            t.Errorf("no next server: %v", err)
+        }
+    })
+
+    t.Run("Required", func (t *testing.T) {
+        strategy := &StrategySticky{}
+        if err := strategy.SetServers(cluster); err == nil {
+            t.Errorf("strategy without Strategy accepts servers")
+        }
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("strategy without Strategy returns server")
+        }
+    })
+}
+
+func TestStrategySticky_Signed(t *testing.T) {
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    strategy := &StrategySticky{Strategy: &StrategyRoundRobin{}, Key: []byte("secret")}
+    strategy.SetServers([]*UpstreamServer{a, b})
+
+    r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+    cookie := stickyResponse(strategy, r, b)
+    if cookie == nil {
+        t.Fatalf("cookie isn't set")
+    }
+    if cookie.Name != "route" || cookie.Path != "/" || cookie.MaxAge != 0 {
+        t.Errorf("cookie is '%s'; want default attributes", cookie)
+    }
+
+    // cookie is id.issued.signature
+    parts := strings.Split(cookie.Value, ".")
+    if len(parts) != 3 {
+        t.Fatalf("cookie '%s' isn't signed", cookie.Value)
+    }
+    id, issued := parts[0], parts[1]
+    partsA := strings.Split(stickyResponse(strategy, r, a).Value, ".")
+    // invalid cookies name b, round robin chooses a for them
+    cases := []struct{
+        name  string
+        value string
+        want  *UpstreamServer
+    }{
+        {"Valid", cookie.Value, b},
+        {"Unsigned", id, a},
+        {"Tampered", id + "." + partsA[1] + "." + partsA[2], a},
+        {"Forged", id + "." + issued + ".AAAA", a},
+        {"Retimed", id + ".1." + parts[2], a},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            strategy.SetServers([]*UpstreamServer{a, b})
+            cr, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+            cr.AddCookie(&http.Cookie{Name: "route", Value: c.value})
+            if next, _ := strategy.Next(cr); next != c.want {
+                t.Errorf("server is '%s'; want '%s'", next, c.want)
+            }
+        })
+    }
+}
+
+func TestStrategySticky_Expired(t *testing.T) {
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    clock := &testClock{now: time.Unix(1000, 0)}
+    strategy := &StrategySticky{
+        Strategy: &StrategyRoundRobin{},
+        TTL: time.Hour,
+        Key: []byte("secret"),
+        Clock: clock,
+    }
+    strategy.SetServers([]*UpstreamServer{a, b})
+
+    r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+    cookie := stickyResponse(strategy, r, b)
+    steps := []struct{
+        elapsed time.Duration
+        want    *UpstreamServer
+    }{
+        {time.Hour, b},
+        {time.Second, a},
+    }
+
+    for i, step := range steps {
+        clock.Add(step.elapsed)
+        // round robin chooses a for request without valid cookie
+        strategy.SetServers([]*UpstreamServer{a, b})
+        cr, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+        cr.AddCookie(cookie)
+        if next, _ := strategy.Next(cr); next != step.want {
+            t.Errorf("%d] server is '%s'; want '%s'", i, next, step.want)
+        }
+    }
+}
+
+func TestProxy_Sticky(t *testing.T) {
+    servers, hits := startCountingBackends(t, 200, 200, 200)
+    strategy := &StrategySticky{Strategy: &StrategyRoundRobin{}}
+    proxy := NewProxy(NewUpstream(servers, strategy))
+    defer proxy.Stop()
+
+    w := httptest.NewRecorder()
+    proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+    cookies := w.Result().Cookies()
+    if len(cookies) != 1 {
+        t.Fatalf("cookies count is %d; want %d", len(cookies), 1)
+    }
+
+    for i := 0; i < 5; i++ {
+        w := httptest.NewRecorder()
+        r := httptest.NewRequest("GET", "/", nil)
+        r.AddCookie(cookies[0])
+        proxy.GetHandler().ServeHTTP(w, r)
+        if len(w.Result().Cookies()) != 0 {
+            t.Errorf("%d] cookie is set again", i)
+        }
+    }
+
+    if hits[0].Load() != 6 {
+        t.Errorf("sticky server hits is %d; want %d", hits[0].Load(), 6)
+    }
+}
@@ -2,39 +2,89 @@ package proxy
 
 import (
     "container/ring"
+    "math"
+    "math/rand/v2"
+    "sync/atomic"
+    "encoding/binary"
+    "strconv"
+    "strings"
     "sync"
     "errors"
     "hash/crc32"
     "sort"
     "net/http"
     "fmt"
+    "context"
 )
 
 // UpstreamStrategy describes interface used to balancing requests to
 // underlying servers.
 type UpstreamStrategy interface {
-    // SetServers  
-    SetServers(servers []*UpstreamServer)
+    // SetServers sets servers to balance requests to. It returns error if
+    // strategy is misconfigured, then old servers are kept.
+    SetServers(servers []*UpstreamServer) error
 
     // Next should returns Server for processing request.
     Next(r *http.Request) (*UpstreamServer, error)
 }
 
+// A ResponseStrategy is UpstreamStrategy which modifies responses of servers
+// it has chosen, for example to bind client to server. Proxy calls
+// ModifyResponse before response is passed to client.
+type ResponseStrategy interface {
+    UpstreamStrategy
+
+    // ModifyResponse modifies response res of server to request r.
+    ModifyResponse(r *http.Request, server *UpstreamServer, res *http.Response)
+}
+
+// triedKey is request context key of servers already tried for request.
+type triedKey struct{}
+
+// triedServers is set of servers already tried for request.
+type triedServers map[*UpstreamServer]bool
+
+// withTried returns request with context storing set of tried servers.
+func withTried(r *http.Request, tried triedServers) *http.Request {
+    return r.WithContext(context.WithValue(r.Context(), triedKey{}, tried))
+}
+
+// available returns true if server is online, isn't drained and wasn't
+// tried for request r.
+func available(r *http.Request, srv *UpstreamServer) bool {
+    if srv == nil || !srv.Online() || srv.Draining() {
+        return false
+    }
+    tried, ok := r.Context().Value(triedKey{}).(triedServers)
+    return !ok || !tried[srv]
+}
+
 // A StrategyRoundRobin realises UpstreamStrategy. Requests are served by server
-// in sequence.
+// in sequence. By default it's Nginx smooth weighted round robin: with
+// weights 5, 1, 1 servers are interleaved as a a b a c a a, not a a a a a b c.
 type StrategyRoundRobin struct {
+    // Sequential makes every server serve weight requests in a row before
+    // moving to next server.
+    Sequential bool
+
     // wc is weight counter, each request served by server increase this counter.
     // When it reaches server's weight, it should be reset
     wc   uint
 
     // ring stores servers
     ring *ring.Ring
+
+    // servers and current are servers and their current weights of smooth
+    // weighted round robin.
+    servers []*UpstreamServer
+    current []int
+
     mux  sync.Mutex
 }
 
 // SetServers creates new Ring filled with servers.
 // Method is safe for concurrent access.
-func (s *StrategyRoundRobin) SetServers(servers []*UpstreamServer) {
+func (s *StrategyRoundRobin) SetServers(servers []*UpstreamServer) error {
     s.mux.Lock()
     defer s.mux.Unlock()
 
@@ -45,21 +95,29 @@ func (s *StrategyRoundRobin) SetServers(servers []*UpstreamServer) {
     }
 
     s.ring = r
+    s.servers = servers
+    s.current = make([]int, len(servers))
+    return nil
 }
 
-// Next returns online server in sequence.
+// Next returns online server in sequence skipping servers already tried for
+// request.
 // Method is safe for concurrent access.
 func (us *StrategyRoundRobin) Next(r *http.Request) (*UpstreamServer, error) {
     us.mux.Lock()
     defer us.mux.Unlock()
 
+    if !us.Sequential {
+        return us.smooth(r)
+    }
+
     next := us.ring
     for i := 0; i < us.ring.Len(); i++ {
         srv, ok := next.Value.(*UpstreamServer)
         if ok  {
-            if srv != nil && srv.online {
+            if available(r, srv) {
                 us.wc += 1
-                if us.wc == 0 || us.wc >= uint(srv.weight) {
+                if us.wc == 0 || us.wc >= srv.Weight() {
                     us.ring = next.Next()
                     us.wc = 0
                 }
@@ -72,8 +130,35 @@ func (us *StrategyRoundRobin) Next(r *http.Request) (*UpstreamServer, error) {
     return nil, errors.New("no valid servers")
 }
 
+// smooth returns server chosen by smooth weighted round robin: every
+// available server's current weight is increased by its effective weight,
+// server with greatest current weight is chosen and its current weight is
+// decreased by total effective weight. Caller must hold us.mux.
+func (us *StrategyRoundRobin) smooth(r *http.Request) (*UpstreamServer, error) {
+    best := -1
+    total := 0
+    for i, srv := range us.servers {
+        if !available(r, srv) {
+            continue
+        }
+        w := int(srv.recoverWeight())
+        us.current[i] += w
+        total += w
+        if best < 0 || us.current[i] > us.current[best] {
+            best = i
+        }
+    }
+
+    if best < 0 {
+        return nil, errors.New("no valid servers")
+    }
+    us.current[best] -= total
+    return us.servers[best], nil
+}
+
 // A StrategyLeastConn realises UpstreamStrategy. Requests are served by server
-// with least connections count.
+// with least connections count in proportion to weight. Ties are broken
+// randomly, so idle servers get requests evenly.
 type StrategyLeastConn struct {
     mux     sync.Mutex
     servers []*UpstreamServer
@@ -81,64 +166,340 @@ type StrategyLeastConn struct {
 
 // SetServers sets servers to manage in Next method.
 // Method is safe for concurrent access.
-func (s *StrategyLeastConn) SetServers(servers []*UpstreamServer) {
+func (s *StrategyLeastConn) SetServers(servers []*UpstreamServer) error {
     s.mux.Lock()
     defer s.mux.Unlock()
     s.servers = servers
+    return nil
 }
 
-// Next resturns first online server with least number of active connections.
+// Next returns available server with least connections/weight ratio,
+// random one of equally loaded servers.
 // Method is safe for concurrent access.
 func (s *StrategyLeastConn) Next(r *http.Request) (*UpstreamServer, error) {
-    if len(s.servers) < 1 {
+    s.mux.Lock()
+    servers := s.servers
+    s.mux.Unlock()
+    if len(servers) < 1 {
         return nil, errors.New("empty upstreams")
     }
+    return pickLeast(r, servers, compareLoad)
+}
 
+// pickLeast returns available server which is least by compare, random one
+// of equal servers.
+func pickLeast(r *http.Request, servers []*UpstreamServer, compare func (a, b *UpstreamServer) int) (*UpstreamServer, error) {
     var next *UpstreamServer
-    var err error
-    min := s.servers[0].connections
-    for i := range s.servers {
-        srv := s.servers[i]
-        if !srv.online {
+    ties := 0
+    for i := range servers {
+        srv := servers[i]
+        if !available(r, srv) {
             continue
         }
 
-        if srv.connections == 0 {
+        if next != nil {
+            c := compare(srv, next)
+            if c > 0 {
+                continue
+            }
+            if c == 0 {
+                // choose each of equal servers with equal probability
+                ties++
+                if rand.IntN(ties) != 0 {
+                    continue
+                }
+            } else {
+                ties = 1
+            }
+        } else {
+            ties = 1
+        }
+        next = srv
+    }
+
+    if next == nil {
+        return nil, errors.New("no valid servers")
+    }
+    return next, nil
+}
+
+// compareLoad compares connections/weight ratios of servers and returns -1
+// if a is less loaded than b, 1 if it's more loaded and 0 if loads are
+// equal.
+func compareLoad(a, b *UpstreamServer) int {
+    x := uint64(a.Connections()) * uint64(b.Weight())
+    y := uint64(b.Connections()) * uint64(a.Weight())
+    switch {
+    case x < y:
+        return -1
+    case x > y:
+        return 1
+    }
+    return 0
+}
+
+// A StrategyTwoChoices realises UpstreamStrategy. It's weighted least
+// connections by power of two random choices: of two random servers less
+// loaded one is chosen. Next takes no locks besides servers counters, so
+// the strategy scales to hundreds of servers.
+type StrategyTwoChoices struct {
+    servers atomic.Pointer[[]*UpstreamServer]
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyTwoChoices) SetServers(servers []*UpstreamServer) error {
+    s.servers.Store(&servers)
+    return nil
+}
+
+// Next returns less loaded of two random available servers.
+// Method is safe for concurrent access.
+func (s *StrategyTwoChoices) Next(r *http.Request) (*UpstreamServer, error) {
+    p := s.servers.Load()
+    if p == nil || len(*p) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+    return pickTwoChoices(r, *p, compareLoad)
+}
+
+// twoChoicesAttempts limits random picks of unavailable servers before
+// all servers are scanned.
+const twoChoicesAttempts = 8
+
+// pickTwoChoices returns lesser by compare of two random available servers.
+// If random picks hit unavailable servers, least of all available servers
+// is returned.
+func pickTwoChoices(r *http.Request, servers []*UpstreamServer, compare func (a, b *UpstreamServer) int) (*UpstreamServer, error) {
+    if len(servers) == 1 {
+        if available(r, servers[0]) {
+            return servers[0], nil
+        }
+        return nil, errors.New("no valid servers")
+    }
+
+    for attempt := 0; attempt < twoChoicesAttempts; attempt++ {
+        i := rand.IntN(len(servers))
+        j := rand.IntN(len(servers) - 1)
+        if j >= i {
+            j++
+        }
+        a, b := servers[i], servers[j]
+        aok, bok := available(r, a), available(r, b)
+        switch {
+        case aok && bok:
+            c := compare(a, b)
+            if c < 0 || (c == 0 && rand.IntN(2) == 0) {
+                return a, nil
+            }
+            return b, nil
+        case aok && attempt == twoChoicesAttempts - 1:
+            return a, nil
+        case bok && attempt == twoChoicesAttempts - 1:
+            return b, nil
+        }
+    }
+
+    // most servers are unavailable
+    return pickLeast(r, servers, compare)
+}
+
+// A StrategyRandom realises UpstreamStrategy. Requests are served by random
+// available server, weights are ignored. Next takes no locks unless Rand is
+// set, so the strategy suits stateless pools with many concurrent requests.
+type StrategyRandom struct {
+    // Rand is source of random numbers, it's used under lock. Default is
+    // global source of math/rand/v2.
+    Rand *rand.Rand
+
+    servers atomic.Pointer[[]*UpstreamServer]
+    mux     sync.Mutex
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyRandom) SetServers(servers []*UpstreamServer) error {
+    s.servers.Store(&servers)
+    return nil
+}
+
+// Next returns random available server.
+// Method is safe for concurrent access.
+func (s *StrategyRandom) Next(r *http.Request) (*UpstreamServer, error) {
+    p := s.servers.Load()
+    if p == nil || len(*p) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+    servers := *p
+
+    for attempt := 0; attempt < randomAttempts; attempt++ {
+        if srv := servers[randN(&s.mux, s.Rand, uint64(len(servers)))]; available(r, srv) {
             return srv, nil
         }
+    }
 
-        if srv.connections < min {
-            min = srv.connections
+    // most servers are unavailable, choose among available ones
+    var next *UpstreamServer
+    n := uint64(0)
+    for _, srv := range servers {
+        if !available(r, srv) {
+            continue
+        }
+        n++
+        if randN(&s.mux, s.Rand, n) == 0 {
             next = srv
         }
     }
+    if next == nil {
+        return nil, errors.New("no valid servers")
+    }
+    return next, nil
+}
+
+// A StrategyWeightedRandom realises UpstreamStrategy. Requests are served by
+// random available server with probability proportional to its weight.
+// Weights are cached and read again after weight of any server is changed.
+// Next takes no locks unless Rand is set.
+type StrategyWeightedRandom struct {
+    // Rand is source of random numbers, it's used under lock. Default is
+    // global source of math/rand/v2.
+    Rand *rand.Rand
 
+    servers atomic.Pointer[weightedServers]
+    mux     sync.Mutex
+}
+
+// weightsVersion is changed by SetWeight of any server, so cached weights
+// are known to be stale.
+var weightsVersion atomic.Uint64
+
+// weightedServers is servers list with cumulative sums of their weights.
+type weightedServers struct {
+    servers []*UpstreamServer
+    sums    []uint64
+
+    // version is weightsVersion before weights were read.
+    version uint64
+}
+
+// newWeightedServers returns servers with sums of their current weights.
+func newWeightedServers(servers []*UpstreamServer) *weightedServers {
+    ws := &weightedServers{
+        servers: servers,
+        sums: make([]uint64, len(servers)),
+        version: weightsVersion.Load(),
+    }
+    total := uint64(0)
+    for i, srv := range servers {
+        total += uint64(srv.Weight())
+        ws.sums[i] = total
+    }
+    return ws
+}
+
+// SetServers sets servers to manage in Next method.
+// Method is safe for concurrent access.
+func (s *StrategyWeightedRandom) SetServers(servers []*UpstreamServer) error {
+    s.servers.Store(newWeightedServers(servers))
+    return nil
+}
+
+// Next returns random available server chosen in proportion to weights.
+// Method is safe for concurrent access.
+func (s *StrategyWeightedRandom) Next(r *http.Request) (*UpstreamServer, error) {
+    ws := s.servers.Load()
+    if ws == nil || len(ws.servers) == 0 {
+        return nil, errors.New("empty upstreams")
+    }
+    if ws.version != weightsVersion.Load() {
+        // weights are changed since sums were computed
+        fresh := newWeightedServers(ws.servers)
+        s.servers.CompareAndSwap(ws, fresh)
+        ws = fresh
+    }
+
+    total := ws.sums[len(ws.sums) - 1]
+    for attempt := 0; attempt < randomAttempts; attempt++ {
+        x := randN(&s.mux, s.Rand, total)
+        i := sort.Search(len(ws.sums), func (i int) bool { return ws.sums[i] > x })
+        if srv := ws.servers[i]; available(r, srv) {
+            return srv, nil
+        }
+    }
+
+    // most servers are unavailable, choose among available ones
+    var next *UpstreamServer
+    sum := uint64(0)
+    for i, srv := range ws.servers {
+        if !available(r, srv) {
+            continue
+        }
+        w := ws.sums[i]
+        if i > 0 {
+            w -= ws.sums[i - 1]
+        }
+        sum += w
+        if randN(&s.mux, s.Rand, sum) < w {
+            next = srv
+        }
+    }
     if next == nil {
-        err = errors.New("no valid servers")
+        return nil, errors.New("no valid servers")
     }
-    return next, err
+    return next, nil
+}
+
+// randomAttempts limits random picks of unavailable servers before
+// available servers are listed.
+const randomAttempts = 8
+
+// randN returns random number in [0, n) from source rnd locked by mux, or
+// from global source if rnd is nil.
+func randN(mux *sync.Mutex, rnd *rand.Rand, n uint64) uint64 {
+    if rnd == nil {
+        return rand.Uint64N(n)
+    }
+    mux.Lock()
+    defer mux.Unlock()
+    return rnd.Uint64N(n)
 }
 
 // A StrategyConsistentHashing realises UpstreamStrategy. Server is selected in
 // static way by ketama hashing algorithm. The strategy  ensures that only a
 // few keys will be remapped to different servers when a server is added to or
 // removed from the upstream. This strategy is compatible with Nginx consistent
-// hashing.
+// hashing (hash $key consistent) when KetamaPoints is default and servers are
+// configured in nginx as host:port with the same hosts, ports and weights.
+//
+// With LoadFactor set strategy works in consistent hashing with bounded
+// loads mode: key walks clockwise on the ring past servers whose active
+// connections count reaches (1 + LoadFactor) times average load in
+// proportion to weight. Mapping is the same as without bounds while servers
+// are not overloaded.
 type StrategyConsistentHashing struct {
     mux    sync.Mutex
     points []KetamaPoint
 
-    // KetamaPoints defines how many points are generated for each server.
-    // Default value is 180.
+    // servers are servers of points.
+    servers []*UpstreamServer
+
+    // KetamaPoints defines how many points are generated for each unit of
+    // server's weight. Default value is 160 like in Nginx.
     KetamaPoints uint
 
     // BackupCount is count of servers returned for hashing key generated in
     // GetKey method. Default is 2
     BackupCount uint
 
-    // GetKey returns hashing key from http.Request structure.
-    // It must be realised by user.
+    // GetKey returns hashing key from http.Request structure. It's
+    // required, ready-made keys are returned by KeyURI, KeyHeader,
+    // KeyTemplate and other Key functions.
     GetKey func (r *http.Request) (string, error)
+
+    // LoadFactor is allowed excess of server load over average, for
+    // example 0.25 allows 125% of average. Zero disables bounded loads,
+    // then BackupCount servers are tried.
+    LoadFactor float64
 }
 
 type KetamaPoint struct {
@@ -146,57 +507,100 @@ type KetamaPoint struct {
     server *UpstreamServer
 }
 
-// SetServers set strategy servers.
-func (s *StrategyConsistentHashing) SetServers(servers []*UpstreamServer) {
+// SetServers builds new ketama points of servers and replaces old ones. It
+// returns error if GetKey is nil.
+// Method is safe for concurrent access.
+func (s *StrategyConsistentHashing) SetServers(servers []*UpstreamServer) error {
     s.mux.Lock()
     defer s.mux.Unlock()
 
+    if s.GetKey == nil {
+        return errors.New("consistent hashing requires GetKey")
+    }
+    if s.LoadFactor < 0 {
+        return errors.New("consistent hashing load factor can't be negative")
+    }
+
     if s.BackupCount == 0 {
         s.BackupCount = 2
     }
 
     if s.KetamaPoints == 0 {
-        s.KetamaPoints = 180
+        s.KetamaPoints = 160
     }
 
     // generate points
+    points := make([]KetamaPoint, 0)
     for i := range servers {
-        var phash, hash uint32
-
         srv := servers[i]
-        n := uint(srv.weight) * s.KetamaPoints
-
-        for i := uint(0); i < n; i++ {
-            hash = crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s\\0%d%d", srv.Host(), srv.Port(), phash)))
-            s.points = append(s.points, KetamaPoint{hash, srv})
-            phash = hash
-        }
+        n := uint(srv.Weight()) * s.KetamaPoints
+        points = appendKetamaPoints(points, srv, n)
     }
 
     // sort points
-    sort.Slice(s.points, func (i, j int) bool {
-        return s.points[i].hash < s.points[j].hash
+    sort.Slice(points, func (i, j int) bool {
+        return points[i].hash < points[j].hash
     })
 
-    for i, j := 0, 1; j < len(s.points); j++ {
-        if s.points[i].hash != s.points[j].hash {
-            i++
-            s.points[i] = s.points[j]
+    // remove duplicates
+    if len(points) > 0 {
+        i := 0
+        for j := 1; j < len(points); j++ {
+            if points[i].hash != points[j].hash {
+                i++
+                points[i] = points[j]
+            }
         }
+        points = points[:i+1]
+    }
+
+    // points slice is never modified after this, so readers may use it
+    // without lock
+    s.points = points
+    s.servers = servers
+    return nil
+}
+
+// appendKetamaPoints appends n points of server srv to points. Like in Nginx
+// and Cache::Memcached::Fast point hash is crc32(HOST \0 PORT PREV_HASH),
+// where PREV_HASH is previous point hash in little endian byte order and
+// zero for first point.
+func appendKetamaPoints(points []KetamaPoint, srv *UpstreamServer, n uint) []KetamaPoint {
+    host := srv.Host()
+    if strings.IndexByte(host, ':') >= 0 {
+        // nginx keeps brackets of IPv6 address
+        host = "[" + host + "]"
+    }
+    base := crc32.ChecksumIEEE([]byte(host + "\x00" + strconv.Itoa(int(srv.Port()))))
+
+    var prev [4]byte
+    for i := uint(0); i < n; i++ {
+        hash := crc32.Update(base, crc32.IEEETable, prev[:])
+        points = append(points, KetamaPoint{hash, srv})
+        binary.LittleEndian.PutUint32(prev[:], hash)
     }
+    return points
 }
 
-// findPoint finds point position for specified key.
-func (s *StrategyConsistentHashing) findPoint(key string) int {
+// snapshot returns current points and backup count.
+func (s *StrategyConsistentHashing) snapshot() ([]KetamaPoint, uint) {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    return s.points, s.BackupCount
+}
+
+// findPoint finds position of first point with hash not less than key's
+// hash. It returns len(points) if there is no such point.
+func findPoint(points []KetamaPoint, key string) int {
     i := 0
-    j := len(s.points)
+    j := len(points)
     hash := crc32.ChecksumIEEE([]byte(key))
 
     for ; i < j; {
         k := (i+j) / 2
-        if hash > s.points[k].hash {
+        if hash > points[k].hash {
             i = k + 1
-        } else if hash < s.points[k].hash {
+        } else if hash < points[k].hash {
             j = k
         } else {
             return k
@@ -207,30 +611,68 @@ func (s *StrategyConsistentHashing) findPoint(key string) int {
 }
 
 // getServers returns servers for specified key.
+// Method is safe for concurrent access.
 func (s *StrategyConsistentHashing) getServers(key string, count uint) []*UpstreamServer {
+    points, _ := s.snapshot()
+    return pointsServers(points, key, count)
+}
+
+// pointsServers returns up to count different servers of points following
+// key. Like Nginx passes request to next server, points of servers already
+// returned are skipped.
+func pointsServers(points []KetamaPoint, key string, count uint) []*UpstreamServer {
     servers := make([]*UpstreamServer, 0)
-    point := s.findPoint(key)
-    for i := uint(0); i < count; i++ {
-        k := point % len(s.points)
-        servers = append(servers, s.points[k].server)
-        point++
+    if len(points) == 0 {
+        return servers
+    }
+    seen := make(map[*UpstreamServer]bool)
+    point := findPoint(points, key)
+    for i := 0; i < len(points) && uint(len(servers)) < count; i++ {
+        srv := points[(point + i) % len(points)].server
+        if !seen[srv] {
+            seen[srv] = true
+            servers = append(servers, srv)
+        }
     }
 
     return servers
 }
 
-// Next
+// Next returns first available server of BackupCount servers following
+// request's key.
+// Method is safe for concurrent access.
 func (s *StrategyConsistentHashing) Next(r *http.Request) (*UpstreamServer, error) {
-    key, err := s.GetKey(r)
+    s.mux.Lock()
+    getKey := s.GetKey
+    s.mux.Unlock()
+    if getKey == nil {
+        return nil, errors.New("consistent hashing requires GetKey")
+    }
+
+    key, err := getKey(r)
     if err != nil {
         return nil, fmt.Errorf("can't get hashing key: %w", err)
     }
 
+    s.mux.Lock()
+    factor := s.LoadFactor
+    all := s.servers
+    s.mux.Unlock()
+
     var next *UpstreamServer
-    servers := s.getServers(key, s.BackupCount)
+    points, count := s.snapshot()
+    if factor > 0 {
+        next = boundedServer(r, points, all, key, factor)
+        if next == nil {
+            err = errors.New("no valid servers")
+        }
+        return next, err
+    }
+
+    servers := pointsServers(points, key, count)
     for i := range servers {
         srv := servers[i]
-        if srv.online {
+        if available(r, srv) {
             next = srv
             break
         }
@@ -242,3 +684,44 @@ func (s *StrategyConsistentHashing) Next(r *http.Request) (*UpstreamServer, erro
 
     return next, err
 }
+
+// boundedServer returns first available server following key on the ring
+// whose load is under capacity. Capacity of server is
+// ceil((1 + factor) * (load + 1) * weight / totalWeight), where load is
+// connections count of available servers.
+func boundedServer(r *http.Request, points []KetamaPoint, servers []*UpstreamServer, key string, factor float64) *UpstreamServer {
+    var load, weight uint
+    for _, srv := range servers {
+        if available(r, srv) {
+            load += srv.Connections()
+            weight += uint(srv.Weight())
+        }
+    }
+    if weight == 0 || len(points) == 0 {
+        return nil
+    }
+
+    limit := (1 + factor) * float64(load + 1) / float64(weight)
+    var fallback *UpstreamServer
+    seen := make(map[*UpstreamServer]bool)
+    point := findPoint(points, key)
+    for i := 0; i < len(points) && len(seen) < len(servers); i++ {
+        srv := points[(point + i) % len(points)].server
+        if seen[srv] {
+            continue
+        }
+        seen[srv] = true
+        if !available(r, srv) {
+            continue
+        }
+        if fallback == nil {
+            fallback = srv
+        }
+        capacity := uint(math.Ceil(limit * float64(srv.Weight())))
+        if srv.Connections() < capacity {
+            return srv
+        }
+    }
+    // loads changed during walk
+    return fallback
+}
@@ -3,7 +3,11 @@ package proxy
 import (
     "testing"
     "fmt"
+    "math"
+    "math/rand/v2"
     "net/http"
+    "sync"
+    "time"
 )
 
 var servers []*UpstreamServer = []*UpstreamServer{
@@ -51,6 +55,7 @@ func TestStrategyRoundRobin_Next(t *testing.T) {
     })
 
     t.Run("Weighted", func (t *testing.T) {
+        strategy.Sequential = true
         strategy.ring.Move(1) // rewind ring to first server
         servers[0].weight = 3
         servers[1].weight = 5
@@ -91,6 +96,105 @@ func TestStrategyRoundRobin_Next(t *testing.T) {
     })
 }
 
+func TestStrategyRoundRobin_Smooth(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+
+    t.Run("Interleaving", func (t *testing.T) {
+        a := NewUpstreamServer("http://127.0.0.1:8000", 5)
+        b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+        c := NewUpstreamServer("http://127.0.0.1:8002", 1)
+        strategy := StrategyRoundRobin{}
+        strategy.SetServers([]*UpstreamServer{a, b, c})
+
+        want := []*UpstreamServer{a, a, b, a, c, a, a}
+        for round := 0; round < 3; round++ {
+            for i, w := range want {
+                next, err := strategy.Next(r)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if next != w {
+                    t.Errorf("%d.%d] server is '%s'; want '%s'", round, i, next, w)
+                }
+            }
+        }
+    })
+
+    t.Run("WideWeights", func (t *testing.T) {
+        a := NewUpstreamServer("http://127.0.0.1:8000", 3000)
+        b := NewUpstreamServer("http://127.0.0.1:8001", 1000)
+        strategy := StrategyRoundRobin{}
+        strategy.SetServers([]*UpstreamServer{a, b})
+
+        counts := make(map[*UpstreamServer]int)
+        maxRun, run := 0, 0
+        var prev *UpstreamServer
+        for i := 0; i < 4000; i++ {
+            next, _ := strategy.Next(r)
+            counts[next]++
+            if next == prev {
+                run++
+            } else {
+                run = 1
+            }
+            prev = next
+            if run > maxRun {
+                maxRun = run
+            }
+        }
+        if counts[a] != 3000 || counts[b] != 1000 {
+            t.Errorf("counts are %d and %d; want %d and %d", counts[a], counts[b], 3000, 1000)
+        }
+        if maxRun > 3 {
+            t.Errorf("server is returned %d times in a row; want at most %d", maxRun, 3)
+        }
+    })
+
+    t.Run("EffectiveWeight", func (t *testing.T) {
+        a := NewUpstreamServer("http://127.0.0.1:8000", 100).SetMaxErrors(2)
+        b := NewUpstreamServer("http://127.0.0.1:8001", 100)
+        strategy := StrategyRoundRobin{}
+        strategy.SetServers([]*UpstreamServer{a, b})
+
+        a.incrErrors(time.Now())
+        if w := a.EffectiveWeight(); w != 50 {
+            t.Fatalf("effective weight after error is %d; want %d", w, 50)
+        }
+
+        counts := make(map[*UpstreamServer]int)
+        for i := 0; i < 20; i++ {
+            next, _ := strategy.Next(r)
+            counts[next]++
+        }
+        if counts[a] >= counts[b] {
+            t.Errorf("failed server count is %d, healthy is %d; want less", counts[a], counts[b])
+        }
+        for i := 0; i < 30; i++ {
+            strategy.Next(r)
+        }
+        if w := a.EffectiveWeight(); w != 100 {
+            t.Errorf("effective weight is %d after recovery; want %d", w, 100)
+        }
+    })
+
+    t.Run("SkipOffline", func (t *testing.T) {
+        a := NewUpstreamServer("http://127.0.0.1:8000", 5)
+        b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+        a.online = false
+        strategy := StrategyRoundRobin{}
+        strategy.SetServers([]*UpstreamServer{a, b})
+        for i := 0; i < 3; i++ {
+            if next, _ := strategy.Next(r); next != b {
+                t.Errorf("server is '%s'; want '%s'", next, b)
+            }
+        }
+        b.online = false
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server is returned when all are offline")
+        }
+    })
+}
+
 func TestStrategyLeastConn_SetServers(t *testing.T) {
     strategy := StrategyLeastConn{}
     strategy.SetServers(servers)
@@ -115,21 +219,301 @@ func TestStrategyLeastConn_Next(t *testing.T) {
 
     r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
     t.Run("Simple", func (t *testing.T) {
-        for _, server := range servers {
+        seen := make(map[*UpstreamServer]bool)
+        for range servers {
             next, err := strategy.Next(r)
             if err != nil {
                 t.Error(err)
                 return
             }
 
-            if next.String() != server.String() {
-                t.Errorf("server is '%s'; want '%s'", next.String(), server.String())
+            if seen[next] {
+                t.Errorf("server '%s' is returned twice; want idle server", next.String())
+            }
+            seen[next] = true
+            next.incrConnections()
+        }
+    })
+}
+
+func TestStrategyLeastConn_Weighted(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+
+    t.Run("Ratio", func (t *testing.T) {
+        a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+        b := NewUpstreamServer("http://127.0.0.1:8001", 3)
+        a.incrConnections()
+        b.incrConnections()
+        b.incrConnections()
+        strategy := StrategyLeastConn{}
+        strategy.SetServers([]*UpstreamServer{a, b})
+        if next, _ := strategy.Next(r); next != b {
+            t.Errorf("server is '%s'; want '%s'", next, b)
+        }
+    })
+
+    t.Run("FirstOffline", func (t *testing.T) {
+        cluster := hashingCluster(3)
+        cluster[0].online = false
+        for i := 0; i < 5; i++ {
+            cluster[1].incrConnections()
+        }
+        for i := 0; i < 3; i++ {
+            cluster[2].incrConnections()
+        }
+        strategy := StrategyLeastConn{}
+        strategy.SetServers(cluster)
+        if next, _ := strategy.Next(r); next != cluster[2] {
+            t.Errorf("server is '%s'; want '%s'", next, cluster[2])
+        }
+    })
+
+    t.Run("Ties", func (t *testing.T) {
+        cluster := hashingCluster(3)
+        strategy := StrategyLeastConn{}
+        strategy.SetServers(cluster)
+        counts := make(map[*UpstreamServer]int)
+        for i := 0; i < 3000; i++ {
+            next, _ := strategy.Next(r)
+            counts[next]++
+        }
+        for _, srv := range cluster {
+            if counts[srv] < 800 || counts[srv] > 1200 {
+                t.Errorf("idle server '%s' count is %d; want about %d", srv, counts[srv], 1000)
+            }
+        }
+    })
+
+    t.Run("Weights", func (t *testing.T) {
+        for _, strategy := range []UpstreamStrategy{&StrategyLeastConn{}, &StrategyTwoChoices{}} {
+            a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+            b := NewUpstreamServer("http://127.0.0.1:8001", 3)
+            strategy.SetServers([]*UpstreamServer{a, b})
+            for i := 0; i < 400; i++ {
+                next, _ := strategy.Next(r)
+                next.incrConnections()
+            }
+            if a.Connections() != 100 || b.Connections() != 300 {
+                t.Errorf("%T connections are %d and %d; want %d and %d", strategy,
+                    a.Connections(), b.Connections(), 100, 300)
+            }
+        }
+    })
+}
+
+func TestStrategyTwoChoices(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+
+    t.Run("Balance", func (t *testing.T) {
+        cluster := hashingCluster(20)
+        strategy := StrategyTwoChoices{}
+        strategy.SetServers(cluster)
+        for i := 0; i < 2000; i++ {
+            next, err := strategy.Next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            next.incrConnections()
+        }
+        for _, srv := range cluster {
+            if c := srv.Connections(); c < 95 || c > 105 {
+                t.Errorf("server '%s' connections is %d; want about %d", srv, c, 100)
+            }
+        }
+    })
+
+    t.Run("SkipOffline", func (t *testing.T) {
+        cluster := hashingCluster(10)
+        for _, srv := range cluster[1:] {
+            srv.online = false
+        }
+        strategy := StrategyTwoChoices{}
+        strategy.SetServers(cluster)
+        for i := 0; i < 100; i++ {
+            if next, _ := strategy.Next(r); next != cluster[0] {
+                t.Fatalf("server is '%s'; want '%s'", next, cluster[0])
+            }
+        }
+        cluster[0].online = false
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server is returned when all are offline")
+        }
+    })
+
+    t.Run("Empty", func (t *testing.T) {
+        strategy := StrategyTwoChoices{}
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server is returned by empty strategy")
+        }
+    })
+}
+
+func TestStrategyLeastConn_Concurrent(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    for _, strategy := range []UpstreamStrategy{&StrategyLeastConn{}, &StrategyTwoChoices{}} {
+        cluster := hashingCluster(5)
+        strategy.SetServers(cluster)
+
+        var wg sync.WaitGroup
+        for i := 0; i < 8; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for j := 0; j < 200; j++ {
+                    next, err := strategy.Next(r)
+                    if err != nil {
+                        t.Error(err)
+                        return
+                    }
+                    next.incrConnections()
+                    next.decrConnections()
+                    if j % 50 == 0 {
+                        strategy.SetServers(cluster)
+                    }
+                }
+            }()
+        }
+        wg.Wait()
+    }
+}
+
+func BenchmarkStrategyLeastConn(b *testing.B) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    for _, strategy := range []UpstreamStrategy{&StrategyLeastConn{}, &StrategyTwoChoices{}} {
+        strategy.SetServers(hashingCluster(300))
+        b.Run(fmt.Sprintf("%T", strategy), func (b *testing.B) {
+            b.RunParallel(func (pb *testing.PB) {
+                for pb.Next() {
+                    next, _ := strategy.Next(r)
+                    next.incrConnections()
+                    next.decrConnections()
+                }
+            })
+        })
+    }
+}
+
+func TestStrategyRandom(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 2)
+    c := NewUpstreamServer("http://127.0.0.1:8002", 3)
+    cluster := []*UpstreamServer{a, b, c}
+
+    cases := []struct{
+        strategy UpstreamStrategy
+        want     []int
+    }{
+        {&StrategyRandom{Rand: rand.New(rand.NewPCG(1, 2))}, []int{2000, 2000, 2000}},
+        {&StrategyWeightedRandom{Rand: rand.New(rand.NewPCG(1, 2))}, []int{1000, 2000, 3000}},
+    }
+
+    for _, cs := range cases {
+        t.Run(fmt.Sprintf("%T", cs.strategy), func (t *testing.T) {
+            strategy := cs.strategy
+            if _, err := strategy.Next(r); err == nil {
+                t.Errorf("server is returned by empty strategy")
+            }
+            strategy.SetServers(cluster)
+
+            counts := make(map[*UpstreamServer]int)
+            for i := 0; i < 6000; i++ {
+                next, err := strategy.Next(r)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                counts[next]++
+            }
+            for i, srv := range cluster {
+                if math.Abs(float64(counts[srv] - cs.want[i])) > float64(cs.want[i]) * 0.1 {
+                    t.Errorf("%d] server '%s' count is %d; want about %d", i, srv, counts[srv], cs.want[i])
+                }
+            }
+
+            b.online = false
+            defer func() { b.online = true }()
+            for i := 0; i < 100; i++ {
+                if next, _ := strategy.Next(r); next == b {
+                    t.Fatalf("offline server '%s' is returned", b)
+                }
+            }
+
+            // only c is available for request which tried a
+            tr := withTried(r, triedServers{a: true})
+            for i := 0; i < 100; i++ {
+                if next, _ := strategy.Next(tr); next != c {
+                    t.Fatalf("server is '%s'; want '%s'", next, c)
+                }
+            }
+            if _, err := strategy.Next(withTried(r, triedServers{a: true, c: true})); err == nil {
+                t.Errorf("server is returned when all are unavailable")
             }
-            next.connections += 1
+        })
+    }
+
+    t.Run("Deterministic", func (t *testing.T) {
+        x := &StrategyWeightedRandom{Rand: rand.New(rand.NewPCG(7, 7))}
+        y := &StrategyWeightedRandom{Rand: rand.New(rand.NewPCG(7, 7))}
+        x.SetServers(cluster)
+        y.SetServers(cluster)
+        for i := 0; i < 100; i++ {
+            nx, _ := x.Next(r)
+            ny, _ := y.Next(r)
+            if nx != ny {
+                t.Fatalf("%d] servers are '%s' and '%s'; want equal", i, nx, ny)
+            }
+        }
+    })
+
+    t.Run("SetWeight", func (t *testing.T) {
+        x := NewUpstreamServer("http://127.0.0.1:8003", 1)
+        y := NewUpstreamServer("http://127.0.0.1:8004", 1)
+        strategy := &StrategyWeightedRandom{Rand: rand.New(rand.NewPCG(1, 2))}
+        strategy.SetServers([]*UpstreamServer{x, y})
+        y.SetWeight(3)
+
+        counts := make(map[*UpstreamServer]int)
+        for i := 0; i < 4000; i++ {
+            next, _ := strategy.Next(r)
+            counts[next]++
+        }
+        if math.Abs(float64(counts[y] - 3000)) > 300 {
+            t.Errorf("server '%s' count is %d; want about %d", y, counts[y], 3000)
         }
     })
 }
 
+func TestStrategyRandom_Concurrent(t *testing.T) {
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+    strategies := []UpstreamStrategy{
+        &StrategyRandom{},
+        &StrategyWeightedRandom{},
+        &StrategyWeightedRandom{Rand: rand.New(rand.NewPCG(1, 2))},
+    }
+    for _, strategy := range strategies {
+        cluster := hashingCluster(5)
+        strategy.SetServers(cluster)
+
+        var wg sync.WaitGroup
+        for i := 0; i < 8; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for j := 0; j < 200; j++ {
+                    if _, err := strategy.Next(r); err != nil {
+                        t.Error(err)
+                        return
+                    }
+                    if j % 50 == 0 {
+                        strategy.SetServers(cluster)
+                    }
+                }
+            }()
+        }
+        wg.Wait()
+    }
+}
+
 func TestStrategyConsistentHashing(t *testing.T) {
     strategy := StrategyConsistentHashing{
         GetKey: func (r *http.Request) (string, error) {
@@ -145,6 +529,19 @@ func TestStrategyConsistentHashing(t *testing.T) {
         }
     })
 
+    t.Run("SetServersTwice", func (t *testing.T) {
+        points := len(strategy.points)
+        strategy.SetServers(servers)
+        if len(strategy.points) != points {
+            t.Errorf("generated points is '%d'; want '%d'", len(strategy.points), points)
+        }
+        for i := 1; i < len(strategy.points); i++ {
+            if strategy.points[i-1].hash >= strategy.points[i].hash {
+                t.Fatalf("points are not sorted or not unique at %d", i)
+            }
+        }
+    })
+
     t.Run("getServers", func (t *testing.T) {
         wantServers := uint(2)
         servers := strategy.getServers("zzzztestkey", wantServers)
@@ -171,7 +568,7 @@ func TestStrategyConsistentHashing(t *testing.T) {
             s1, err := strategy.Next(r)
             s2, err := strategy.Next(r)
             if err != nil {
-                t.Errorf("no next server: %w", err)
+                t.Errorf("no next server: %v", err)
             }
 
             if s1 != s2 {
@@ -180,3 +577,230 @@ func TestStrategyConsistentHashing(t *testing.T) {
         }
     })
 }
+
+func TestStrategyConsistentHashing_Remap(t *testing.T) {
+    const n = 10
+    const keys = 10000
+
+    cluster := make([]*UpstreamServer, 0, n + 1)
+    for i := 0; i <= n; i++ {
+        cluster = append(cluster, NewUpstreamServer(fmt.Sprintf("http://10.0.0.%d:80", i + 1), 1))
+    }
+    strategy := StrategyConsistentHashing{
+        GetKey: func (r *http.Request) (string, error) {
+            return r.URL.Path, nil
+        },
+    }
+
+    // mapping returns servers of keys.
+    mapping := func (servers []*UpstreamServer) []*UpstreamServer {
+        strategy.SetServers(servers)
+        ret := make([]*UpstreamServer, keys)
+        for i := range ret {
+            r, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1/key/%d", i), nil)
+            srv, err := strategy.Next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            ret[i] = srv
+        }
+        return ret
+    }
+
+    before := mapping(cluster[:n])
+    after := mapping(cluster)
+
+    t.Run("Add", func (t *testing.T) {
+        moved := 0
+        for i := range before {
+            if before[i] == after[i] {
+                continue
+            }
+            moved++
+            if after[i] != cluster[n] {
+                t.Fatalf("key %d moved from '%s' to old server '%s'", i, before[i], after[i])
+            }
+        }
+        // about 1/(n+1) of keys moves to added server
+        if want := keys / (n + 1); moved < want / 2 || moved > want * 2 {
+            t.Errorf("moved keys is %d; want about %d", moved, want)
+        }
+    })
+
+    t.Run("Remove", func (t *testing.T) {
+        removed := mapping(cluster[:n])
+        for i := range removed {
+            if removed[i] != before[i] {
+                t.Fatalf("key %d is mapped to '%s' after remove; want '%s'", i, removed[i], before[i])
+            }
+        }
+    })
+
+    t.Run("Empty", func (t *testing.T) {
+        strategy.SetServers(nil)
+        r, _ := http.NewRequest("GET", "http://127.0.0.1/key", nil)
+        if _, err := strategy.Next(r); err == nil {
+            t.Errorf("server returned by empty strategy")
+        }
+    })
+}
+
+func TestStrategyConsistentHashing_Concurrent(t *testing.T) {
+    strategy := StrategyConsistentHashing{
+        GetKey: func (r *http.Request) (string, error) {
+            return r.URL.Path, nil
+        },
+    }
+    strategy.SetServers(servers)
+    r, _ := http.NewRequest("GET", "http://127.0.0.1/key", nil)
+
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < 100; i++ {
+            strategy.SetServers(servers[:1 + i % len(servers)])
+        }
+    }()
+    go func() {
+        defer wg.Done()
+        for i := 0; i < 100; i++ {
+            if _, err := strategy.Next(r); err != nil {
+                t.Error(err)
+                return
+            }
+        }
+    }()
+    wg.Wait()
+}
+
+func TestStrategyConsistentHashing_Nginx(t *testing.T) {
+    // reference values are computed by independent implementation of nginx
+    // ngx_http_upstream_init_chash_peer and find_chash_point
+    cluster := []*UpstreamServer{
+        NewUpstreamServer("http://10.0.0.1:80", 1),
+        NewUpstreamServer("http://10.0.0.2:8080", 2),
+        NewUpstreamServer("http://backend.local:8000", 1),
+        NewUpstreamServer("http://[::1]:9000", 1),
+    }
+    strategy := StrategyConsistentHashing{
+        GetKey: func (r *http.Request) (string, error) {
+            return r.Header.Get("X-Key"), nil
+        },
+    }
+    strategy.SetServers(cluster)
+
+    t.Run("Points", func (t *testing.T) {
+        if len(strategy.points) != 800 {
+            t.Errorf("points count is %d; want %d", len(strategy.points), 800)
+        }
+        points := appendKetamaPoints(nil, cluster[0], 4)
+        for i, want := range []uint32{0xa2ad5d56, 0x0bdeb0ab, 0x75f00c5b, 0x8368489f} {
+            if points[i].hash != want {
+                t.Errorf("%d] point hash is %#08x; want %#08x", i, points[i].hash, want)
+            }
+        }
+    })
+
+    t.Run("Keys", func (t *testing.T) {
+        golden := []struct{
+            key    string
+            server string
+        }{
+            {"/", "http://backend.local:8000"},
+            {"/index.html", "http://10.0.0.1:80"},
+            {"/a/b", "http://backend.local:8000"},
+            {"/z/e", "http://backend.local:8000"},
+            {"/api/v1/users?id=42", "http://[::1]:9000"},
+            {"user-1", "http://10.0.0.1:80"},
+            {"user-2", "http://10.0.0.2:8080"},
+            {"user-3", "http://10.0.0.1:80"},
+            {"192.168.1.10", "http://backend.local:8000"},
+            {"example.com/path", "http://10.0.0.1:80"},
+        }
+        for _, g := range golden {
+            r, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+            r.Header.Set("X-Key", g.key)
+            srv, err := strategy.Next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if srv.String() != g.server {
+                t.Errorf("server of '%s' is '%s'; want '%s'", g.key, srv, g.server)
+            }
+        }
+    })
+}
+
+func TestStrategyConsistentHashing_BoundedLoads(t *testing.T) {
+    cluster := hashingCluster(4)
+    key := func (r *http.Request) (string, error) {
+        return r.URL.Path, nil
+    }
+    plain := &StrategyConsistentHashing{GetKey: key}
+    plain.SetServers(cluster)
+    bounded := &StrategyConsistentHashing{GetKey: key, LoadFactor: 0.25}
+    if err := bounded.SetServers(cluster); err != nil {
+        t.Fatal(err)
+    }
+    reqs := hashingRequests(1000)
+
+    t.Run("Stable", func (t *testing.T) {
+        want := hashingMapping(t, plain, reqs)
+        got := hashingMapping(t, bounded, reqs)
+        for i := range want {
+            if got[i] != want[i] {
+                t.Fatalf("key %d is mapped to '%s'; want '%s'", i, got[i], want[i])
+            }
+        }
+    })
+
+    t.Run("Overloaded", func (t *testing.T) {
+        r := reqs[0]
+        primary := plain.getServers(r.URL.Path, 2)
+        for i := 0; i < 10; i++ {
+            primary[0].incrConnections()
+        }
+        defer func() {
+            for i := 0; i < 10; i++ {
+                primary[0].decrConnections()
+            }
+        }()
+
+        next, err := bounded.Next(r)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if next != primary[1] {
+            t.Errorf("server is '%s'; want next server on ring '%s'", next, primary[1])
+        }
+    })
+
+    t.Run("HotKey", func (t *testing.T) {
+        r := reqs[0]
+        const total = 100
+        for i := 0; i < total; i++ {
+            next, err := bounded.Next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            next.incrConnections()
+        }
+        limit := uint(math.Ceil(1.25 * total / float64(len(cluster))))
+        for _, srv := range cluster {
+            if c := srv.Connections(); c > limit {
+                t.Errorf("server '%s' connections is %d; want at most %d", srv, c, limit)
+            }
+            for srv.Connections() > 0 {
+                srv.decrConnections()
+            }
+        }
+    })
+
+    t.Run("Validate", func (t *testing.T) {
+        s := &StrategyConsistentHashing{GetKey: key, LoadFactor: -1}
+        if err := s.SetServers(cluster); err == nil {
+            t.Errorf("negative load factor is accepted")
+        }
+    })
+}
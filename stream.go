@@ -0,0 +1,124 @@
+package proxy
+
+import (
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// flushInterval returns interval of flushing response res to client.
+// Server-Sent Events, gRPC and responses with unknown length are flushed
+// immediately.
+func (p *Proxy) flushInterval(res *http.Response) time.Duration {
+    ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+    if ct == "text/event-stream" || isGRPC(res.Header) || res.ContentLength == -1 {
+        return -1
+    }
+    return p.FlushInterval
+}
+
+// isGRPC returns true if message with headers h is gRPC message.
+func isGRPC(h http.Header) bool {
+    ct := h.Get("Content-Type")
+    return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") ||
+        strings.HasPrefix(ct, "application/grpc;")
+}
+
+// copyResponse copies body to client flushing it every interval. Negative
+// interval means flush after every write, zero disables flushing. Body of
+// upstream request is bound to client request context, so reading stops
+// when client goes away.
+func copyResponse(w http.ResponseWriter, body io.Reader, interval time.Duration) error {
+    var dst io.Writer = w
+    if interval != 0 {
+        mlw := &maxLatencyWriter{
+            w: w,
+            flush: http.NewResponseController(w).Flush,
+            latency: interval,
+        }
+        defer mlw.stop()
+        // send headers before body, client may wait for them
+        mlw.flush()
+        dst = mlw
+    }
+
+    buf := make([]byte, 32 * 1024)
+    for {
+        n, rerr := body.Read(buf)
+        if n > 0 {
+            if _, werr := dst.Write(buf[:n]); werr != nil {
+                return fmt.Errorf("can't write response: %w", werr)
+            }
+        }
+        if rerr == io.EOF {
+            return nil
+        }
+        if rerr != nil {
+            return fmt.Errorf("%w: %w", rerr, BadGatewayError)
+        }
+    }
+}
+
+// maxLatencyWriter flushes written data at least every latency.
+type maxLatencyWriter struct {
+    w       io.Writer
+    flush   func() error
+    latency time.Duration
+
+    mux          sync.Mutex
+    flushTimer   *time.Timer
+    flushPending bool
+}
+
+// Write writes p and flushes it immediately or schedules flush.
+func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+    m.mux.Lock()
+    defer m.mux.Unlock()
+
+    n, err := m.w.Write(p)
+    if m.latency < 0 {
+        m.doFlush()
+        return n, err
+    }
+    if m.flushPending {
+        return n, err
+    }
+    if m.flushTimer == nil {
+        m.flushTimer = time.AfterFunc(m.latency, m.delayedFlush)
+    } else {
+        m.flushTimer.Reset(m.latency)
+    }
+    m.flushPending = true
+    return n, err
+}
+
+// delayedFlush flushes pending data by timer.
+func (m *maxLatencyWriter) delayedFlush() {
+    m.mux.Lock()
+    defer m.mux.Unlock()
+    if !m.flushPending {
+        // stopped
+        return
+    }
+    m.doFlush()
+}
+
+// doFlush flushes written data. Caller must hold m.mux.
+func (m *maxLatencyWriter) doFlush() {
+    m.flush()
+    m.flushPending = false
+}
+
+// stop cancels scheduled flush.
+func (m *maxLatencyWriter) stop() {
+    m.mux.Lock()
+    defer m.mux.Unlock()
+    m.flushPending = false
+    if m.flushTimer != nil {
+        m.flushTimer.Stop()
+    }
+}
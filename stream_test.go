@@ -0,0 +1,102 @@
+package proxy
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestProxy_flushInterval(t *testing.T) {
+    proxy := &Proxy{FlushInterval: time.Second}
+
+    cases := []struct{
+        name  string
+        ctype string
+        len   int64
+        want  time.Duration
+    }{
+        {"Default", "text/html", 10, time.Second},
+        {"EventStream", "text/event-stream; charset=utf-8", 10, -1},
+        {"UnknownLength", "text/html", -1, -1},
+    }
+
+    for _, c := range cases {
+        res := &http.Response{
+            Header: http.Header{"Content-Type": {c.ctype}},
+            ContentLength: c.len,
+        }
+        if got := proxy.flushInterval(res); got != c.want {
+            t.Errorf("%s: interval is %v; want %v", c.name, got, c.want)
+        }
+    }
+}
+
+func TestProxy_Streaming(t *testing.T) {
+    release := make(chan struct{})
+    cancelled := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/event-stream")
+        for i := 0; ; i++ {
+            fmt.Fprintf(w, "data: %d\n\n", i)
+            w.(http.Flusher).Flush()
+            select {
+            case <-release:
+            case <-r.Context().Done():
+                close(cancelled)
+                return
+            }
+        }
+    }))
+    defer backend.Close()
+
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(backend.URL, 1)}, &StrategyRoundRobin{}))
+    defer proxy.Stop()
+    front := httptest.NewServer(proxy.GetHandler())
+    defer front.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    req, _ := http.NewRequestWithContext(ctx, "GET", front.URL, nil)
+    res, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer res.Body.Close()
+
+    events := make(chan string)
+    go func() {
+        scanner := bufio.NewScanner(res.Body)
+        for scanner.Scan() {
+            if line := scanner.Text(); line != "" {
+                select {
+                case events <- line:
+                case <-ctx.Done():
+                    return
+                }
+            }
+        }
+        close(events)
+    }()
+
+    for i := 0; i < 3; i++ {
+        select {
+        case event := <-events:
+            if want := fmt.Sprintf("data: %d", i); event != want {
+                t.Fatalf("event is '%s'; want '%s'", event, want)
+            }
+        case <-time.After(time.Second * 5):
+            t.Fatalf("event %d is not flushed", i)
+        }
+        release <- struct{}{}
+    }
+
+    cancel()
+    select {
+    case <-cancelled:
+    case <-time.After(time.Second * 5):
+        t.Fatalf("upstream request is not cancelled with client request")
+    }
+}
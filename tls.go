@@ -0,0 +1,105 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "os"
+)
+
+// A TLSOptions defines TLS parameters of connections to https servers.
+// Zero values mean Go defaults.
+type TLSOptions struct {
+    // RootCAs is pool of certificate authorities used to verify servers
+    // certificates. Default is system pool.
+    RootCAs *x509.CertPool
+
+    // Certificates are client certificates presented to servers requiring
+    // mutual TLS.
+    Certificates []tls.Certificate
+
+    // ServerName overrides name used for SNI and verification of server
+    // certificate. Default is server's host.
+    ServerName string
+
+    // MinVersion is minimal TLS version, for example tls.VersionTLS12.
+    MinVersion uint16
+
+    // CipherSuites limits cipher suites of TLS 1.2 and earlier connections.
+    CipherSuites []uint16
+
+    // InsecureSkipVerify disables verification of servers certificates.
+    // Use it only for development.
+    InsecureSkipVerify bool
+}
+
+// LoadCertPool returns pool of PEM encoded certificates read from files.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+    pool := x509.NewCertPool()
+    for _, f := range files {
+        data, err := os.ReadFile(f)
+        if err != nil {
+            return nil, err
+        }
+        if !pool.AppendCertsFromPEM(data) {
+            return nil, fmt.Errorf("no certificates found in %s", f)
+        }
+    }
+    return pool, nil
+}
+
+// validate returns error if options are invalid.
+func (o *TLSOptions) validate() error {
+    if o == nil {
+        return nil
+    }
+    switch o.MinVersion {
+    case 0, tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13:
+    default:
+        return fmt.Errorf("unknown TLS version %#04x", o.MinVersion)
+    }
+
+    known := make(map[uint16]bool)
+    for _, s := range tls.CipherSuites() {
+        known[s.ID] = true
+    }
+    for _, s := range tls.InsecureCipherSuites() {
+        known[s.ID] = true
+    }
+    for _, id := range o.CipherSuites {
+        if !known[id] {
+            return fmt.Errorf("unknown cipher suite %#04x", id)
+        }
+    }
+    return nil
+}
+
+// config returns tls.Config built from options.
+func (o *TLSOptions) config() *tls.Config {
+    if o == nil {
+        return &tls.Config{}
+    }
+    return &tls.Config{
+        RootCAs: o.RootCAs,
+        Certificates: o.Certificates,
+        ServerName: o.ServerName,
+        MinVersion: o.MinVersion,
+        CipherSuites: o.CipherSuites,
+        InsecureSkipVerify: o.InsecureSkipVerify,
+    }
+}
+
+// validateTLS returns error if server can't use its TLS options.
+func validateTLS(server *UpstreamServer) error {
+    opts := server.TLS()
+    if opts == nil {
+        return nil
+    }
+    if server.Proto() != "https" {
+        return fmt.Errorf("server %s: TLS options require https", server)
+    }
+    if err := opts.validate(); err != nil {
+        return fmt.Errorf("server %s: %w", server, err)
+    }
+    return nil
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// newTestCertificate returns self-signed certificate valid for names.
+func newTestCertificate(t *testing.T, names ...string) tls.Certificate {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject: pkix.Name{CommonName: names[0]},
+        NotBefore: time.Now().Add(-time.Hour),
+        NotAfter: time.Now().Add(time.Hour),
+        KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+        BasicConstraintsValid: true,
+        IsCA: true,
+    }
+    for _, name := range names {
+        if ip := net.ParseIP(name); ip != nil {
+            tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+        } else {
+            tmpl.DNSNames = append(tmpl.DNSNames, name)
+        }
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    leaf, _ := x509.ParseCertificate(der)
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestLoadCertPool(t *testing.T) {
+    cert := newTestCertificate(t, "example.com")
+    file := filepath.Join(t.TempDir(), "ca.pem")
+    data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+    if err := os.WriteFile(file, data, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    pool, err := LoadCertPool(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "example.com"}); err != nil {
+        t.Errorf("certificate is not verified with loaded pool: %v", err)
+    }
+
+    if _, err := LoadCertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+        t.Errorf("missing file is loaded")
+    }
+}
+
+func TestProxy_TLS(t *testing.T) {
+    var sni string
+    backend := httptest.NewUnstartedServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        sni = r.TLS.ServerName
+    }))
+    backend.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+    backend.StartTLS()
+    defer backend.Close()
+    roots := x509.NewCertPool()
+    roots.AddCert(backend.Certificate())
+
+    client := newTestCertificate(t, "client")
+    clientCAs := x509.NewCertPool()
+    clientCAs.AddCert(client.Leaf)
+    mtls := httptest.NewUnstartedServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {}))
+    mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+    mtls.StartTLS()
+    defer mtls.Close()
+    roots.AddCert(mtls.Certificate())
+
+    cases := []struct{
+        name   string
+        server *UpstreamServer
+        opts   *TLSOptions
+        status int
+        sni    string
+    }{
+        {"UnknownCA", NewUpstreamServer(backend.URL, 1), nil, http.StatusBadGateway, ""},
+        {"RootCAs", NewUpstreamServer(backend.URL, 1), &TLSOptions{RootCAs: roots}, http.StatusOK, ""},
+        {"Insecure", NewUpstreamServer(backend.URL, 1), &TLSOptions{InsecureSkipVerify: true}, http.StatusOK, ""},
+        {"ServerName", NewUpstreamServer(backend.URL, 1),
+            &TLSOptions{RootCAs: roots, ServerName: "example.com"}, http.StatusOK, "example.com"},
+        {"MinVersion", NewUpstreamServer(backend.URL, 1),
+            &TLSOptions{RootCAs: roots, MinVersion: tls.VersionTLS13}, http.StatusBadGateway, ""},
+        {"NoClientCertificate", NewUpstreamServer(mtls.URL, 1), &TLSOptions{RootCAs: roots}, http.StatusBadGateway, ""},
+        {"ClientCertificate", NewUpstreamServer(mtls.URL, 1),
+            &TLSOptions{RootCAs: roots, Certificates: []tls.Certificate{client}}, http.StatusOK, ""},
+        {"ServerOptions", NewUpstreamServer(backend.URL, 1).SetTLS(&TLSOptions{RootCAs: roots}),
+            &TLSOptions{MinVersion: tls.VersionTLS13}, http.StatusOK, ""},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            sni = ""
+            u, err := NewUpstreamWithOptions([]*UpstreamServer{c.server}, &StrategyRoundRobin{}, UpstreamOptions{TLS: c.opts})
+            if err != nil {
+                t.Fatal(err)
+            }
+            proxy := NewProxy(u)
+            defer proxy.Stop()
+            front := httptest.NewServer(proxy.GetHandler())
+            defer front.Close()
+
+            res, err := http.Get(front.URL)
+            if err != nil {
+                t.Fatal(err)
+            }
+            res.Body.Close()
+            if res.StatusCode != c.status {
+                t.Errorf("status is %d; want %d", res.StatusCode, c.status)
+            }
+            if c.sni != "" && sni != c.sni {
+                t.Errorf("server name is '%s'; want '%s'", sni, c.sni)
+            }
+        })
+    }
+
+    t.Run("Validate", func (t *testing.T) {
+        invalid := []struct{
+            server *UpstreamServer
+            opts   *TLSOptions
+        }{
+            {NewUpstreamServer(backend.URL, 1), &TLSOptions{MinVersion: 0x0200}},
+            {NewUpstreamServer(backend.URL, 1), &TLSOptions{CipherSuites: []uint16{0xffff}}},
+            {NewUpstreamServer(backend.URL, 1).SetTLS(&TLSOptions{MinVersion: 0x0200}), nil},
+            {NewUpstreamServer("http://127.0.0.1:8080", 1).SetTLS(&TLSOptions{}), nil},
+        }
+        for i, c := range invalid {
+            _, err := NewUpstreamWithOptions([]*UpstreamServer{c.server}, &StrategyRoundRobin{}, UpstreamOptions{TLS: c.opts})
+            if err == nil {
+                t.Errorf("%d] invalid TLS options accepted", i)
+            }
+        }
+    })
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "time"
+)
+
+// A TransportOptions defines parameters of connections to upstream servers.
+// Zero values are replaced with defaults.
+type TransportOptions struct {
+    // DialTimeout limits time of connection establishing.
+    // Default is 30 seconds.
+    DialTimeout time.Duration
+
+    // KeepAlive is interval between TCP keep-alive probes.
+    // Default is 30 seconds.
+    KeepAlive time.Duration
+
+    // TLSHandshakeTimeout limits time of TLS handshake.
+    // Default is 10 seconds.
+    TLSHandshakeTimeout time.Duration
+
+    // ResponseHeaderTimeout limits time of waiting for response headers
+    // after request is sent. Default is 60 seconds.
+    ResponseHeaderTimeout time.Duration
+
+    // IdleConnTimeout is time after which idle connection is closed.
+    // Default is 90 seconds.
+    IdleConnTimeout time.Duration
+
+    // MaxIdleConns limits number of idle connections to all servers.
+    // Default is 100.
+    MaxIdleConns int
+
+    // MaxIdleConnsPerHost limits number of idle connections to each server.
+    // Default is 32.
+    MaxIdleConnsPerHost int
+
+    // MaxConnsPerHost limits number of connections to each server.
+    // Zero means no limit.
+    MaxConnsPerHost int
+
+    // DisableKeepAlives disables reusing of connections.
+    DisableKeepAlives bool
+}
+
+// Protocol is HTTP protocol used to connect to upstream server.
+type Protocol int
+
+const (
+    // ProtocolHTTP1 is HTTP/1.1 over TCP or TLS. It's default.
+    ProtocolHTTP1 Protocol = iota
+
+    // ProtocolHTTP2 is HTTP/2 over TLS, server's proto must be https.
+    ProtocolHTTP2
+
+    // ProtocolH2C is cleartext HTTP/2 with prior knowledge, server's proto
+    // must be http.
+    ProtocolH2C
+)
+
+// protocols lists supported protocols.
+var protocols = []Protocol{ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C}
+
+// String returns protocol name.
+func (p Protocol) String() string {
+    switch p {
+    case ProtocolHTTP1:
+        return "http/1.1"
+    case ProtocolHTTP2:
+        return "h2"
+    case ProtocolH2C:
+        return "h2c"
+    }
+    return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+// validateProtocol returns error if server can't use its protocol.
+func validateProtocol(server *UpstreamServer) error {
+    switch server.Protocol() {
+    case ProtocolHTTP1:
+        return nil
+    case ProtocolHTTP2:
+        if server.Proto() != "https" {
+            return fmt.Errorf("server %s: h2 requires https", server)
+        }
+        return nil
+    case ProtocolH2C:
+        if server.Proto() != "http" {
+            return fmt.Errorf("server %s: h2c requires http", server)
+        }
+        return nil
+    }
+    return fmt.Errorf("server %s: unknown protocol %s", server, server.Protocol())
+}
+
+// A UpstreamOptions defines Upstream parameters.
+type UpstreamOptions struct {
+    // Transport defines connections to servers.
+    Transport TransportOptions
+
+    // HostPolicy defines Host header of requests passed to servers.
+    // Default is HostUpstream.
+    HostPolicy HostPolicy
+
+    // Host is Host header of requests when HostPolicy is HostFixed.
+    Host string
+
+    // TLS defines connections to https servers. Server's own options set
+    // by UpstreamServer.SetTLS take precedence.
+    TLS *TLSOptions
+}
+
+// validate returns error if options are invalid.
+func (o TransportOptions) validate() error {
+    if o.DialTimeout < 0 || o.KeepAlive < 0 || o.TLSHandshakeTimeout < 0 ||
+        o.ResponseHeaderTimeout < 0 || o.IdleConnTimeout < 0 {
+        return errors.New("transport timeouts can't be negative")
+    }
+    if o.MaxIdleConns < 0 || o.MaxIdleConnsPerHost < 0 || o.MaxConnsPerHost < 0 {
+        return errors.New("transport connections limits can't be negative")
+    }
+    return nil
+}
+
+// withDefaults returns options with zero values replaced by defaults.
+func (o TransportOptions) withDefaults() TransportOptions {
+    if o.DialTimeout == 0 {
+        o.DialTimeout = time.Second * 30
+    }
+    if o.KeepAlive == 0 {
+        o.KeepAlive = time.Second * 30
+    }
+    if o.TLSHandshakeTimeout == 0 {
+        o.TLSHandshakeTimeout = time.Second * 10
+    }
+    if o.ResponseHeaderTimeout == 0 {
+        o.ResponseHeaderTimeout = time.Second * 60
+    }
+    if o.IdleConnTimeout == 0 {
+        o.IdleConnTimeout = time.Second * 90
+    }
+    if o.MaxIdleConns == 0 {
+        o.MaxIdleConns = 100
+    }
+    if o.MaxIdleConnsPerHost == 0 {
+        o.MaxIdleConnsPerHost = 32
+    }
+    return o
+}
+
+// newTransport returns http.Transport speaking protocol and configured with
+// options and TLS options tlsOpts. The transport never follows redirects and
+// doesn't use environment proxy.
+func newTransport(o TransportOptions, protocol Protocol, tlsOpts *TLSOptions) *http.Transport {
+    o = o.withDefaults()
+    dialer := &net.Dialer{
+        Timeout: o.DialTimeout,
+        KeepAlive: o.KeepAlive,
+    }
+
+    protocols := new(http.Protocols)
+    switch protocol {
+    case ProtocolHTTP2:
+        protocols.SetHTTP2(true)
+    case ProtocolH2C:
+        protocols.SetUnencryptedHTTP2(true)
+    default:
+        protocols.SetHTTP1(true)
+    }
+
+    return &http.Transport{
+        Protocols: protocols,
+        TLSClientConfig: tlsOpts.config(),
+        DialContext: dialer.DialContext,
+        TLSHandshakeTimeout: o.TLSHandshakeTimeout,
+        ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+        IdleConnTimeout: o.IdleConnTimeout,
+        MaxIdleConns: o.MaxIdleConns,
+        MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+        MaxConnsPerHost: o.MaxConnsPerHost,
+        DisableKeepAlives: o.DisableKeepAlives,
+        DisableCompression: true,
+    }
+}
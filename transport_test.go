@@ -0,0 +1,162 @@
+package proxy
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestTransportOptions(t *testing.T) {
+    t.Run("Validate", func (t *testing.T) {
+        invalid := []TransportOptions{
+            {DialTimeout: -1},
+            {ResponseHeaderTimeout: -time.Second},
+            {MaxIdleConnsPerHost: -1},
+        }
+        for i, o := range invalid {
+            _, err := NewUpstreamWithOptions(servers, &StrategyRoundRobin{}, UpstreamOptions{Transport: o})
+            if err == nil {
+                t.Errorf("%d] invalid options accepted", i)
+            }
+        }
+    })
+
+    t.Run("Defaults", func (t *testing.T) {
+        tr := newTransport(TransportOptions{MaxConnsPerHost: 10}, ProtocolHTTP1, nil)
+        if tr.IdleConnTimeout != time.Second * 90 {
+            t.Errorf("IdleConnTimeout is %v; want %v", tr.IdleConnTimeout, time.Second * 90)
+        }
+        if tr.MaxIdleConnsPerHost != 32 {
+            t.Errorf("MaxIdleConnsPerHost is %d; want %d", tr.MaxIdleConnsPerHost, 32)
+        }
+        if tr.MaxConnsPerHost != 10 {
+            t.Errorf("MaxConnsPerHost is %d; want %d", tr.MaxConnsPerHost, 10)
+        }
+        if tr.Proxy != nil {
+            t.Errorf("transport uses environment proxy")
+        }
+    })
+}
+
+func TestProxy_Transport(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Query().Get("case") {
+        case "redirect":
+            http.Redirect(w, r, "/target", http.StatusFound)
+        case "slow":
+            time.Sleep(time.Millisecond * 200)
+        }
+    }))
+    defer backend.Close()
+
+    server := NewUpstreamServer(backend.URL, 1).SetMaxErrors(0)
+    u, err := NewUpstreamWithOptions([]*UpstreamServer{server}, &StrategyRoundRobin{}, UpstreamOptions{
+        Transport: TransportOptions{ResponseHeaderTimeout: time.Millisecond * 50},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    proxy := NewProxy(u)
+    defer proxy.Stop()
+
+    t.Run("Redirect", func (t *testing.T) {
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/?case=redirect", nil))
+        if w.Code != http.StatusFound {
+            t.Errorf("status is %d; want %d", w.Code, http.StatusFound)
+        }
+        if w.Header().Get("Location") != "/target" {
+            t.Errorf("Location is '%s'; want '%s'", w.Header().Get("Location"), "/target")
+        }
+    })
+
+    t.Run("ResponseHeaderTimeout", func (t *testing.T) {
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, httptest.NewRequest("GET", "/?case=slow", nil))
+        if w.Code != http.StatusGatewayTimeout {
+            t.Errorf("status is %d; want %d", w.Code, http.StatusGatewayTimeout)
+        }
+    })
+}
+
+func TestProxy_Protocols(t *testing.T) {
+    handler := http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/grpc")
+        w.Header().Set("Trailer", "Grpc-Status")
+        w.Header().Set("X-Proto", r.Proto)
+        io.Copy(w, r.Body)
+        w.Header().Set("Grpc-Status", "0")
+    })
+
+    h2c := httptest.NewUnstartedServer(handler)
+    h2c.Config.Protocols = new(http.Protocols)
+    h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+    h2c.Start()
+    defer h2c.Close()
+
+    h2 := httptest.NewUnstartedServer(handler)
+    h2.EnableHTTP2 = true
+    h2.StartTLS()
+    defer h2.Close()
+
+    cases := []struct{
+        name     string
+        server   *UpstreamServer
+        proto    string
+    }{
+        {"H2C", NewUpstreamServer(h2c.URL, 1).SetProtocol(ProtocolH2C), "HTTP/2.0"},
+        {"HTTP2", NewUpstreamServer(h2.URL, 1).SetProtocol(ProtocolHTTP2), "HTTP/2.0"},
+        {"HTTP1", NewUpstreamServer(h2.URL, 1), "HTTP/1.1"},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func (t *testing.T) {
+            u, err := NewUpstreamWithOptions([]*UpstreamServer{c.server}, &StrategyRoundRobin{}, UpstreamOptions{
+                TLS: &TLSOptions{RootCAs: h2.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs},
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            proxy := NewProxy(u)
+            defer proxy.Stop()
+            front := httptest.NewServer(proxy.GetHandler())
+            defer front.Close()
+
+            req, _ := http.NewRequest("POST", front.URL, strings.NewReader("message"))
+            req.Header.Set("Content-Type", "application/grpc")
+            req.Header.Set("Te", "trailers")
+            res, err := http.DefaultClient.Do(req)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer res.Body.Close()
+            body, _ := io.ReadAll(res.Body)
+
+            if res.Header.Get("X-Proto") != c.proto {
+                t.Errorf("upstream protocol is '%s'; want '%s'", res.Header.Get("X-Proto"), c.proto)
+            }
+            if string(body) != "message" {
+                t.Errorf("body is '%s'; want '%s'", body, "message")
+            }
+            if res.Trailer.Get("Grpc-Status") != "0" {
+                t.Errorf("Grpc-Status trailer is '%s'; want '%s'", res.Trailer.Get("Grpc-Status"), "0")
+            }
+        })
+    }
+
+    t.Run("Validate", func (t *testing.T) {
+        invalid := []*UpstreamServer{
+            NewUpstreamServer("http://127.0.0.1:8080", 1).SetProtocol(ProtocolHTTP2),
+            NewUpstreamServer("https://127.0.0.1:8443", 1).SetProtocol(ProtocolH2C),
+        }
+        for _, s := range invalid {
+            _, err := NewUpstreamWithOptions([]*UpstreamServer{s}, &StrategyRoundRobin{}, UpstreamOptions{})
+            if err == nil {
+                t.Errorf("server %s with %s accepted", s, s.Protocol())
+            }
+        }
+    })
+}
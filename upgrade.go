@@ -0,0 +1,134 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// isUpgrade returns true if client requests protocol upgrade, for example to
+// WebSocket.
+func isUpgrade(h http.Header) bool {
+    return h.Get("Upgrade") != "" && headerHasToken(h, "Connection", "upgrade")
+}
+
+// checkUpgrade returns error if server switched to protocol other than
+// requested by client.
+func checkUpgrade(r *http.Request, res *http.Response) error {
+    reqType := r.Header.Get("Upgrade")
+    resType := res.Header.Get("Upgrade")
+    if !strings.EqualFold(reqType, resType) {
+        return fmt.Errorf("server switched to protocol %q when %q was requested: %w",
+            resType, reqType, BadGatewayError)
+    }
+    if _, ok := res.Body.(io.ReadWriteCloser); !ok {
+        return fmt.Errorf("switching protocols response body is not writable: %w", BadGatewayError)
+    }
+    return nil
+}
+
+// canHijack returns true if client connection of w can be hijacked, HTTP/2
+// connections can't.
+func canHijack(w http.ResponseWriter) bool {
+    for {
+        switch rw := w.(type) {
+        case http.Hijacker:
+            return true
+        case interface{ Unwrap() http.ResponseWriter }:
+            w = rw.Unwrap()
+        default:
+            return false
+        }
+    }
+}
+
+// hijack takes over client connection of w for tunnel.
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+    conn, brw, err := http.NewResponseController(w).Hijack()
+    if err != nil {
+        return nil, nil, fmt.Errorf("can't hijack client connection: %v: %w", err, BadGatewayError)
+    }
+    return conn, brw, nil
+}
+
+// tunnel sends switching protocols response to hijacked client connection
+// and pipes bytes between client and server until one side closes
+// connection, connection is idle for UpgradeIdleTimeout or it lives for
+// UpgradeMaxLifetime.
+func (p *Proxy) tunnel(conn net.Conn, brw *bufio.ReadWriter, res *http.Response) error {
+    backConn := res.Body.(io.ReadWriteCloser)
+    defer backConn.Close()
+    defer conn.Close()
+
+    header := make(http.Header)
+    responseHeaders(res, header)
+    header.Set("Connection", "Upgrade")
+    header.Set("Upgrade", res.Header.Get("Upgrade"))
+    sres := &http.Response{
+        Status: res.Status,
+        StatusCode: res.StatusCode,
+        Proto: "HTTP/1.1",
+        ProtoMajor: 1,
+        ProtoMinor: 1,
+        Header: header,
+    }
+    if err := sres.Write(brw); err != nil {
+        return fmt.Errorf("can't write switching protocols response: %w", err)
+    }
+    if err := brw.Flush(); err != nil {
+        return fmt.Errorf("can't write switching protocols response: %w", err)
+    }
+
+    closeAll := sync.OnceFunc(func () {
+        conn.Close()
+        backConn.Close()
+    })
+
+    var idle *time.Timer
+    if p.UpgradeIdleTimeout > 0 {
+        idle = time.AfterFunc(p.UpgradeIdleTimeout, closeAll)
+        defer idle.Stop()
+    }
+    if p.UpgradeMaxLifetime > 0 {
+        lifetime := time.AfterFunc(p.UpgradeMaxLifetime, closeAll)
+        defer lifetime.Stop()
+    }
+
+    pipe := func (dst io.Writer, src io.Reader, errc chan<- error) {
+        buf := make([]byte, 32 * 1024)
+        for {
+            n, err := src.Read(buf)
+            if n > 0 {
+                if idle != nil {
+                    idle.Reset(p.UpgradeIdleTimeout)
+                }
+                if _, werr := dst.Write(buf[:n]); werr != nil {
+                    errc <- werr
+                    return
+                }
+            }
+            if err != nil {
+                errc <- err
+                return
+            }
+        }
+    }
+
+    errc := make(chan error, 2)
+    go pipe(backConn, brw, errc)
+    go pipe(conn, backConn, errc)
+    err := <-errc
+    closeAll()
+    <-errc
+
+    if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+        return nil
+    }
+    return err
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// echoUpgradeHandler switches connection to protocol which echoes lines.
+func echoUpgradeHandler(protocol string) http.Handler {
+    return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        if !isUpgrade(r.Header) {
+            http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+            return
+        }
+        conn, brw, err := http.NewResponseController(w).Hijack()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", protocol)
+        brw.Flush()
+        for {
+            line, err := brw.ReadString('\n')
+            if err != nil {
+                return
+            }
+            brw.WriteString(line)
+            brw.Flush()
+        }
+    })
+}
+
+// dialUpgrade sends upgrade request to addr and returns connection and
+// response.
+func dialUpgrade(t *testing.T, addr, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", addr, protocol)
+    br := bufio.NewReader(conn)
+    res, err := http.ReadResponse(br, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return conn, br, res
+}
+
+func TestProxy_Upgrade(t *testing.T) {
+    backend := httptest.NewServer(echoUpgradeHandler("echo"))
+    defer backend.Close()
+
+    server := NewUpstreamServer(backend.URL, 1)
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{server}, &StrategyLeastConn{}))
+    proxy.UpgradeIdleTimeout = time.Millisecond * 300
+    defer proxy.Stop()
+    front := httptest.NewServer(proxy.GetHandler())
+    defer front.Close()
+    addr := front.Listener.Addr().String()
+
+    t.Run("Echo", func (t *testing.T) {
+        conn, br, res := dialUpgrade(t, addr, "echo")
+        defer conn.Close()
+
+        if res.StatusCode != http.StatusSwitchingProtocols {
+            t.Fatalf("status is %d; want %d", res.StatusCode, http.StatusSwitchingProtocols)
+        }
+        if res.Header.Get("Upgrade") != "echo" {
+            t.Errorf("Upgrade is '%s'; want '%s'", res.Header.Get("Upgrade"), "echo")
+        }
+
+        for _, msg := range []string{"ping\n", "pong\n"} {
+            io.WriteString(conn, msg)
+            line, err := br.ReadString('\n')
+            if err != nil {
+                t.Fatal(err)
+            }
+            if line != msg {
+                t.Errorf("echo is '%s'; want '%s'", line, msg)
+            }
+        }
+
+        if connections := server.Connections(); connections != 1 {
+            t.Errorf("server connections is %d; want %d", connections, 1)
+        }
+
+        conn.Close()
+        deadline := time.Now().Add(time.Second * 5)
+        for server.Connections() != 0 && time.Now().Before(deadline) {
+            time.Sleep(time.Millisecond * 10)
+        }
+        if connections := server.Connections(); connections != 0 {
+            t.Errorf("server connections after close is %d; want %d", connections, 0)
+        }
+    })
+
+    t.Run("IdleTimeout", func (t *testing.T) {
+        conn, br, _ := dialUpgrade(t, addr, "echo")
+        defer conn.Close()
+
+        conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+        if _, err := br.ReadString('\n'); err != io.EOF {
+            t.Errorf("idle connection read error is '%v'; want EOF", err)
+        }
+    })
+
+    t.Run("Mismatch", func (t *testing.T) {
+        other := httptest.NewServer(echoUpgradeHandler("other"))
+        defer other.Close()
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(other.URL, 1)}, &StrategyRoundRobin{}))
+        defer proxy.Stop()
+        front := httptest.NewServer(proxy.GetHandler())
+        defer front.Close()
+
+        conn, _, res := dialUpgrade(t, front.Listener.Addr().String(), "echo")
+        defer conn.Close()
+        if res.StatusCode != http.StatusBadGateway {
+            t.Errorf("status is %d; want %d", res.StatusCode, http.StatusBadGateway)
+        }
+    })
+}
+
+// failingHijacker is ResponseWriter which fails to hijack connection.
+type failingHijacker struct {
+    *httptest.ResponseRecorder
+}
+
+func (failingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    return nil, nil, errors.New("connection is busy")
+}
+
+func TestProxy_UpgradeNotHijackable(t *testing.T) {
+    var hits atomic.Int32
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        hits.Add(1)
+        echoUpgradeHandler("echo").ServeHTTP(w, r)
+    }))
+    defer backend.Close()
+
+    proxy := NewProxy(NewUpstream([]*UpstreamServer{NewUpstreamServer(backend.URL, 1)}, &StrategyRoundRobin{}))
+    var after atomic.Bool
+    proxy.RegisterAfterHandler(func (next http.Handler) http.Handler {
+        return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+            after.Store(true)
+        })
+    })
+    defer proxy.Stop()
+
+    upgrade := func () *http.Request {
+        r := httptest.NewRequest("GET", "/ws", nil)
+        r.Header.Set("Connection", "Upgrade")
+        r.Header.Set("Upgrade", "echo")
+        return r
+    }
+
+    t.Run("Unsupported", func (t *testing.T) {
+        w := httptest.NewRecorder()
+        proxy.GetHandler().ServeHTTP(w, upgrade())
+        if w.Code != http.StatusNotImplemented {
+            t.Errorf("status is %d; want %d", w.Code, http.StatusNotImplemented)
+        }
+        if hits.Load() != 0 {
+            t.Errorf("upgrade is passed to server")
+        }
+    })
+
+    t.Run("HijackFailed", func (t *testing.T) {
+        w := failingHijacker{httptest.NewRecorder()}
+        proxy.GetHandler().ServeHTTP(w, upgrade())
+        if w.Code != http.StatusBadGateway {
+            t.Errorf("status is %d; want %d", w.Code, http.StatusBadGateway)
+        }
+    })
+
+    t.Run("HTTP2", func (t *testing.T) {
+        server := NewUpstreamServer(backend.URL, 1).SetProtocol(ProtocolH2C)
+        proxy := NewProxy(NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{}))
+        defer proxy.Stop()
+
+        before := hits.Load()
+        w := failingHijacker{httptest.NewRecorder()}
+        proxy.GetHandler().ServeHTTP(w, upgrade())
+        if w.Code != http.StatusNotImplemented {
+            t.Errorf("status is %d; want %d", w.Code, http.StatusNotImplemented)
+        }
+        if hits.Load() != before {
+            t.Errorf("upgrade is passed to HTTP/2 server")
+        }
+        if !server.Online() {
+            t.Errorf("server is offline after rejected upgrade")
+        }
+    })
+
+    if after.Load() {
+        t.Errorf("after handlers run for failed upgrade")
+    }
+}
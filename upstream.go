@@ -1,8 +1,11 @@
 package proxy
 
 import (
+    "context"
+    "errors"
     "fmt"
     "sync"
+    "sync/atomic"
     "strings"
     "strconv"
     "net"
@@ -21,8 +24,28 @@ type UpstreamServer struct {
     // proto is server's protocol - http or https
     proto  string
 
+    // path is prefix of requests paths
+    path   string
+
+    // protocol is HTTP version used to connect to server
+    protocol Protocol
+
+    // tls is server's own TLS options
+    tls *TLSOptions
+
+    // transport sends requests to server with own TLS options, it's
+    // created by Upstream.
+    transport *http.Transport
+
+    // draining server doesn't get new requests
+    draining bool
+
     // weight
-    weight uint8
+    weight uint
+
+    // effectiveWeight is weight used by smooth weighted round robin. It
+    // drops after errors and recovers by one on every balancing round.
+    effectiveWeight uint
 
     // maxErrors default 1
     maxErrors     uint
@@ -33,24 +56,47 @@ type UpstreamServer struct {
     // online status
     online bool
 
+    // healthy is result of active health checks. Server goes online only
+    // if it's healthy.
+    healthy bool
+
+    // checkPasses is consecutive successful health checks count.
+    checkPasses uint
+
+    // checkFails is consecutive failed health checks count.
+    checkFails uint
+
     // errors is errors counter
     errors uint
 
-    // connections is active connections count
-    connections uint
+    // connections is active connections count, it's updated atomically
+    // without mux
+    connections atomic.Int64
+
+    // ttfb is average time to first byte of responses.
+    ttfb ewma
+
+    // total is average time of whole responses.
+    total ewma
+
+    // peak is peak-sensitive average time to first byte.
+    peak ewma
 
-    // done channel controls ticker stopping
-    stop chan struct{}
+    // failures stores moments of errors happened during last errorsTimeout
+    // seconds.
+    failures []time.Time
 
-    // ticker is time.Ticker, used to verify errors rate in time
-    ticker *time.Ticker
+    // failedUntil is moment when server taken offline by errors goes online.
+    // Zero value means server is not taken offline by errors.
+    failedUntil time.Time
 
     mux    sync.Mutex
 }
 
 // NewUpstreamServer returns Server with assigned address and weight.
-// Address format is http(s)://host:port
-func NewUpstreamServer(addr string, weight uint8) *UpstreamServer {
+// Address format is http(s)://host:port[/path], path is prepended to paths
+// of proxied requests.
+func NewUpstreamServer(addr string, weight uint) *UpstreamServer {
     if weight == 0 {
         weight = 1
     }
@@ -61,6 +107,11 @@ func NewUpstreamServer(addr string, weight uint8) *UpstreamServer {
     }
 
     s := strings.TrimPrefix(addr, fmt.Sprintf("%s://", proto))
+    path := ""
+    if i := strings.IndexByte(s, '/'); i >= 0 {
+        path = strings.TrimSuffix(s[i:], "/")
+        s = s[:i]
+    }
     host, sport, err := net.SplitHostPort(s)
     if err != nil {
         panic(fmt.Errorf("can't create server: %w", err))
@@ -73,105 +124,264 @@ func NewUpstreamServer(addr string, weight uint8) *UpstreamServer {
 
     return &UpstreamServer{
         proto: proto,
+        path: path,
         host: host,
         port: uint16(port),
         weight: weight,
+        effectiveWeight: weight,
         maxErrors: 1,
         errorsTimeout: 10,
         online: true,
+        healthy: true,
         errors: 0,
-        connections: 0,
+        peak: ewma{peak: true},
     }
 }
 
 // Host returns server's host.
-func (u UpstreamServer) Host() string {
+func (u *UpstreamServer) Host() string {
     return u.host
 }
 
 // Port returns server's port.
-func (u UpstreamServer) Port() uint16 {
+func (u *UpstreamServer) Port() uint16 {
     return u.port
 }
 
+// SetProtocol sets HTTP protocol used to connect to server. ProtocolHTTP2
+// requires https server, ProtocolH2C requires http server.
+func (u *UpstreamServer) SetProtocol(p Protocol) *UpstreamServer {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    u.protocol = p
+    return u
+}
+
+// Protocol returns HTTP protocol used to connect to server.
+func (u *UpstreamServer) Protocol() Protocol {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.protocol
+}
+
+// SetTLS sets TLS options of connections to https server overriding
+// Upstream options. Options must be set before server is passed to
+// NewUpstream.
+func (u *UpstreamServer) SetTLS(opts *TLSOptions) *UpstreamServer {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    u.tls = opts
+    return u
+}
+
+// TLS returns server's own TLS options.
+func (u *UpstreamServer) TLS() *TLSOptions {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.tls
+}
+
+// Draining reports whether server is drained and doesn't get new requests.
+func (u *UpstreamServer) Draining() bool {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.draining
+}
+
+// setTransport sets server's own transport.
+func (u *UpstreamServer) setTransport(t *http.Transport) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    u.transport = t
+}
+
+// ownTransport returns server's own transport or nil.
+func (u *UpstreamServer) ownTransport() *http.Transport {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.transport
+}
+
+// Path returns prefix of requests paths.
+func (u *UpstreamServer) Path() string {
+    return u.path
+}
+
+// addr returns server's host:port.
+func (u *UpstreamServer) addr() string {
+    return net.JoinHostPort(u.host, strconv.Itoa(int(u.port)))
+}
+
 // Proto returns server's proto.
-func (u UpstreamServer) Proto() string {
+func (u *UpstreamServer) Proto() string {
     return u.proto
 }
 
-// SetWeight sets server's weight.
-func (u *UpstreamServer) SetWeight(w uint8) *UpstreamServer {
+// SetWeight sets server's weight and resets its effective weight.
+func (u *UpstreamServer) SetWeight(w uint) *UpstreamServer {
     u.mux.Lock()
     defer u.mux.Unlock()
     if w == 0 {
         w = 1
     }
     u.weight = w
+    u.effectiveWeight = w
+    weightsVersion.Add(1)
     return u
 }
 
 // Weight returns server's weight.
-func (u UpstreamServer) Weight() uint8 {
+func (u *UpstreamServer) Weight() uint {
+    u.mux.Lock()
+    defer u.mux.Unlock()
     return u.weight
 }
 
+// EffectiveWeight returns weight used by smooth weighted round robin. It's
+// lower than weight after errors.
+func (u *UpstreamServer) EffectiveWeight() uint {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.effectiveWeight
+}
+
+// recoverWeight increases effective weight by one up to weight and returns
+// effective weight before increase.
+func (u *UpstreamServer) recoverWeight() uint {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    w := u.effectiveWeight
+    if u.effectiveWeight < u.weight {
+        u.effectiveWeight++
+    }
+    return w
+}
+
 // SetMaxErrors sets maximum errors in ErrorsTimeout interval then the server
-// goes offline for ErrorsTimeout seconds.
+// goes offline for ErrorsTimeout seconds. Zero disables errors tracking.
 func (u *UpstreamServer) SetMaxErrors(e uint) *UpstreamServer {
     u.mux.Lock()
     defer u.mux.Unlock()
     u.maxErrors = e
-    /*
     if e == 0 {
-        u.ticker.Stop()
-    } else {
-        u.ticker.Reset(time.Second * u.errorsTimeout)
+        u.resetErrors()
     }
-    */
     return u
 }
 
 // GetMaxErrors returns maximum errors.
-func (u UpstreamServer) MaxErrors() uint {
+func (u *UpstreamServer) MaxErrors() uint {
+    u.mux.Lock()
+    defer u.mux.Unlock()
     return u.maxErrors
 }
 
 // SetErrorsTimeout sets time in secods. If during this time server's errors 
-// reach maxErrors, server goes offline for this period.
+// reach maxErrors, server goes offline for this period. Zero disables errors
+// tracking.
 func (u *UpstreamServer) SetErrorsTimeout(t uint) *UpstreamServer {
     u.mux.Lock()
     defer u.mux.Unlock()
     u.errorsTimeout = t
-    /*
     if t == 0 {
-        u.ticker.Stop()
-    } else {
-        u.ticker.Reset(time.Second * t)
+        u.resetErrors()
     }
-    */
     return u
 }
 
 // GetErrorsTimeout returns time in seconds.
-func (u UpstreamServer) ErrorsTimeout() uint {
+func (u *UpstreamServer) ErrorsTimeout() uint {
+    u.mux.Lock()
+    defer u.mux.Unlock()
     return u.errorsTimeout
 }
 
 // incrConnections increments server's connections.
 func (u *UpstreamServer) incrConnections() {
+    u.connections.Add(1)
+}
+
+// acquire increments server's connections unless server is draining. Check
+// and increment are atomic, so Drain sees every connection started before
+// server became draining.
+func (u *UpstreamServer) acquire() bool {
     u.mux.Lock()
     defer u.mux.Unlock()
-    u.connections += 1
+    if u.draining {
+        return false
+    }
+    u.connections.Add(1)
+    return true
+}
+
+// Connections returns number of active connections, including upgraded
+// connections.
+func (u *UpstreamServer) Connections() uint {
+    return uint(u.connections.Load())
 }
 
 // decrConnections decrement server's connections.
 func (u *UpstreamServer) decrConnections() {
+    u.connections.Add(-1)
+}
+
+// observeTTFB records time to first byte d of response received at now.
+func (u *UpstreamServer) observeTTFB(now time.Time, d time.Duration) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    u.ttfb.observe(now, d)
+    u.peak.observe(now, d)
+}
+
+// observeTotal records time d of whole response received at now.
+func (u *UpstreamServer) observeTotal(now time.Time, d time.Duration) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    u.total.observe(now, d)
+}
+
+// observeFailure records response failed after d at now with latency
+// penalty.
+func (u *UpstreamServer) observeFailure(now time.Time, d time.Duration) {
+    d = failureLatency(d)
     u.mux.Lock()
     defer u.mux.Unlock()
-    u.connections -= 1
+    u.ttfb.observe(now, d)
+    u.peak.observe(now, d)
+    u.total.observe(now, d)
 }
 
+// Latency returns average times to first byte and to last byte of server's
+// responses, as of last responses.
+func (u *UpstreamServer) Latency() (ttfb, total time.Duration) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return time.Duration(u.ttfb.value), time.Duration(u.total.value)
+}
+
+// averageLatency returns average time to first byte, or to last byte if
+// lastByte is true, decayed at now.
+func (u *UpstreamServer) averageLatency(now time.Time, lastByte bool) float64 {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    if lastByte {
+        return u.total.get(now)
+    }
+    return u.ttfb.get(now)
+}
+
+// peakLatency returns peak-sensitive average time to first byte decayed at
+// now.
+func (u *UpstreamServer) peakLatency(now time.Time) float64 {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    return u.peak.get(now)
+}
+
+// Online returns true if server can process requests.
 func (u *UpstreamServer) Online() bool {
+    u.mux.Lock()
+    defer u.mux.Unlock()
     return u.online
 }
 
@@ -182,63 +392,514 @@ func (u *UpstreamServer) Errors() uint {
     return u.errors
 }
 
-// incrErrors increments server's connections.
-func (u *UpstreamServer) incrErrors() {
+// incrErrors registers error happened at moment now. If errors number during
+// last ErrorsTimeout seconds reaches MaxErrors, server goes offline for
+// ErrorsTimeout seconds.
+func (u *UpstreamServer) incrErrors(now time.Time) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    // like in nginx effective weight drops by weight / maxErrors
+    if u.maxErrors > 0 {
+        drop := u.weight / u.maxErrors
+        if drop > u.effectiveWeight {
+            drop = u.effectiveWeight
+        }
+        u.effectiveWeight -= drop
+    }
+
+    if u.maxErrors == 0 || u.errorsTimeout == 0 {
+        u.errors += 1
+        return
+    }
+
+    if !u.failedUntil.IsZero() {
+        // server is offline already, errors are forgotten when it's back
+        return
+    }
+
+    u.pruneErrors(now)
+    u.failures = append(u.failures, now)
+    if n := len(u.failures) - int(u.maxErrors); n > 0 {
+        // only last maxErrors errors matter
+        u.failures = append(u.failures[:0], u.failures[n:]...)
+    }
+    u.errors = uint(len(u.failures))
+    if u.online && u.errors >= u.maxErrors {
+        u.online = false
+        u.failedUntil = now.Add(time.Second * time.Duration(u.errorsTimeout))
+    }
+}
+
+// checkErrors forgets errors older than ErrorsTimeout seconds and returns
+// server online when its offline period is over.
+func (u *UpstreamServer) checkErrors(now time.Time) {
     u.mux.Lock()
     defer u.mux.Unlock()
-    u.errors += 1
+
+    if u.failedUntil.IsZero() {
+        u.pruneErrors(now)
+        return
+    }
+
+    if !now.Before(u.failedUntil) {
+        u.resetErrors()
+    }
 }
 
-// decrErrors decrement server's connections.
-func (u *UpstreamServer) decrErrors() {
+// pruneErrors removes errors happened before ErrorsTimeout seconds ago.
+// Caller must hold u.mux.
+func (u *UpstreamServer) pruneErrors(now time.Time) {
+    if u.errorsTimeout == 0 {
+        return
+    }
+
+    since := now.Add(-time.Second * time.Duration(u.errorsTimeout))
+    i := 0
+    for i < len(u.failures) && !u.failures[i].After(since) {
+        i++
+    }
+    u.failures = u.failures[i:]
+    u.errors = uint(len(u.failures))
+}
+
+// resetErrors clears errors and returns healthy server online if it was
+// taken offline by errors. Caller must hold u.mux.
+func (u *UpstreamServer) resetErrors() {
+    if !u.failedUntil.IsZero() {
+        u.online = u.healthy
+        u.failedUntil = time.Time{}
+    }
+    u.failures = nil
+    u.errors = 0
+}
+
+// Healthy returns result of active health checks.
+func (u *UpstreamServer) Healthy() bool {
     u.mux.Lock()
     defer u.mux.Unlock()
-    u.errors -= 1
+    return u.healthy
+}
+
+// checked registers health check result. Server becomes healthy after rise
+// consecutive successful checks and unhealthy after fall consecutive failed
+// checks.
+func (u *UpstreamServer) checked(ok bool, rise, fall uint) {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    if ok {
+        u.checkFails = 0
+        u.checkPasses += 1
+        if !u.healthy && u.checkPasses >= rise {
+            u.healthy = true
+            u.online = u.failedUntil.IsZero()
+        }
+        return
+    }
+
+    u.checkPasses = 0
+    u.checkFails += 1
+    if u.healthy && u.checkFails >= fall {
+        u.healthy = false
+        u.online = false
+    }
+}
+
+// resetHealth makes server healthy and returns it online unless it's
+// taken offline by errors.
+func (u *UpstreamServer) resetHealth() {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    u.checkPasses = 0
+    u.checkFails = 0
+    if !u.healthy {
+        u.healthy = true
+        u.online = u.failedUntil.IsZero()
+    }
 }
 
 // String returns string representations os server.
 func (u *UpstreamServer) String() string {
-    return fmt.Sprintf("%s://%s:%d", u.proto, u.host, u.port)
+    return fmt.Sprintf("%s://%s", u.proto, u.addr())
+}
+
+// errorsCheckInterval is interval between servers errors checks.
+const errorsCheckInterval = time.Second
+
+// drainCheckInterval is interval between checks of drained server
+// connections.
+const drainCheckInterval = time.Millisecond * 50
+
+// Clock is a source of current time. It may be replaced in tests.
+type Clock interface {
+    Now() time.Time
+}
+
+// systemClock is Clock returning time.Now.
+type systemClock struct{}
+
+// Now returns current local time.
+func (systemClock) Now() time.Time {
+    return time.Now()
 }
 
 // A Upstream defines parameters for Proxy.
 type Upstream struct {
     servers  []*UpstreamServer
     strategy UpstreamStrategy
+
+    // transports sends requests to servers, there is transport for every
+    // protocol.
+    transports map[Protocol]*http.Transport
+
+    // transportOptions defines transports of servers with own TLS options.
+    transportOptions TransportOptions
+
+    // hostPolicy defines Host header of requests.
+    hostPolicy HostPolicy
+
+    // host is Host header used by HostFixed policy.
+    host      string
+
+    // clock used to track servers errors. It's read without u.mux on every
+    // request.
+    clock    atomic.Pointer[Clock]
+
+    // stop channel used to stop timers.
+    stop     chan struct{}
+
+    // healthCheck stores active health checks parameters.
+    healthCheck *HealthCheck
+
+    // checksStop channel used to stop health checks.
+    checksStop  chan struct{}
+
+    // checksWg waits for health checks goroutine.
+    checksWg    sync.WaitGroup
+
+    // wg waits for timers goroutines.
+    wg       sync.WaitGroup
+    mux      sync.Mutex
 }
 
-// Create new Upstream
+// Create new Upstream with default options. It never fails: if servers are
+// invalid or strategy rejects them, upstream has no servers and requests get
+// 503 status. Use NewUpstreamWithOptions to get the error.
 func NewUpstream(servers []*UpstreamServer, strategy UpstreamStrategy) *Upstream {
-    strategy.SetServers(servers)
-    return &Upstream{
-        servers: servers,
+    u, err := NewUpstreamWithOptions(servers, strategy, UpstreamOptions{})
+    if err != nil {
+        return newUpstream(strategy, UpstreamOptions{})
+    }
+    return u
+}
+
+// NewUpstreamWithOptions returns new Upstream configured with options.
+func NewUpstreamWithOptions(servers []*UpstreamServer, strategy UpstreamStrategy, opts UpstreamOptions) (*Upstream, error) {
+    if err := opts.Transport.validate(); err != nil {
+        return nil, err
+    }
+    if err := validateHost(opts.HostPolicy, opts.Host); err != nil {
+        return nil, err
+    }
+    if err := opts.TLS.validate(); err != nil {
+        return nil, err
+    }
+    if err := validateServers(servers); err != nil {
+        return nil, err
+    }
+
+    u := newUpstream(strategy, opts)
+    if err := u.setServers(servers); err != nil {
+        return nil, err
+    }
+    return u, nil
+}
+
+// newUpstream returns Upstream without servers configured with valid
+// options.
+func newUpstream(strategy UpstreamStrategy, opts UpstreamOptions) *Upstream {
+    transports := make(map[Protocol]*http.Transport)
+    for _, p := range protocols {
+        transports[p] = newTransport(opts.Transport, p, opts.TLS)
+    }
+
+    u := &Upstream{
         strategy: strategy,
+        transports: transports,
+        transportOptions: opts.Transport,
+        hostPolicy: opts.HostPolicy,
+        host: opts.Host,
+    }
+    u.SetClock(systemClock{})
+    return u
+}
+
+// validateServers returns error if servers can't be used in upstream.
+func validateServers(servers []*UpstreamServer) error {
+    seen := make(map[*UpstreamServer]bool)
+    for _, s := range servers {
+        if s == nil {
+            return errors.New("server is nil")
+        }
+        if seen[s] {
+            return fmt.Errorf("server %s is added twice", s)
+        }
+        seen[s] = true
+        if err := validateProtocol(s); err != nil {
+            return err
+        }
+        if err := validateTLS(s); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// setServers passes servers to strategy and replaces upstream servers if
+// strategy accepts them. Caller must hold u.mux or own u exclusively, so
+// strategy always gets servers in order of changes.
+func (u *Upstream) setServers(servers []*UpstreamServer) error {
+    if err := u.strategy.SetServers(servers); err != nil {
+        return err
+    }
+    for _, s := range servers {
+        if s.TLS() != nil && s.ownTransport() == nil {
+            s.setTransport(newTransport(u.transportOptions, s.Protocol(), s.TLS()))
+        }
+    }
+    u.servers = servers
+    return nil
+}
+
+// AddServer adds server to upstream. Method is safe for concurrent access.
+func (u *Upstream) AddServer(server *UpstreamServer) error {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    servers := make([]*UpstreamServer, len(u.servers), len(u.servers) + 1)
+    copy(servers, u.servers)
+    servers = append(servers, server)
+    if err := validateServers(servers); err != nil {
+        return err
+    }
+    return u.setServers(servers)
+}
+
+// RemoveServer removes server from upstream. Requests in progress are not
+// interrupted. Method is safe for concurrent access.
+func (u *Upstream) RemoveServer(server *UpstreamServer) error {
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    servers := make([]*UpstreamServer, 0, len(u.servers))
+    for _, s := range u.servers {
+        if s != server {
+            servers = append(servers, s)
+        }
+    }
+    if len(servers) == len(u.servers) {
+        return fmt.Errorf("server %s is not found", server)
+    }
+    if err := u.setServers(servers); err != nil {
+        return err
+    }
+    closeServerConnections(server)
+    return nil
+}
+
+// ReplaceServers replaces all upstream servers. Requests in progress are
+// not interrupted. Method is safe for concurrent access.
+func (u *Upstream) ReplaceServers(servers []*UpstreamServer) error {
+    if err := validateServers(servers); err != nil {
+        return err
+    }
+
+    u.mux.Lock()
+    defer u.mux.Unlock()
+
+    kept := make(map[*UpstreamServer]bool)
+    for _, s := range servers {
+        kept[s] = true
     }
+    old := u.servers
+    if err := u.setServers(append([]*UpstreamServer(nil), servers...)); err != nil {
+        return err
+    }
+    for _, s := range old {
+        if !kept[s] {
+            closeServerConnections(s)
+        }
+    }
+    return nil
+}
+
+// Drain stops passing new requests to server, waits for its active
+// connections to finish and removes server from upstream. If ctx is done
+// before, server gets new requests again and ctx error is returned, use
+// RemoveServer to remove it anyway.
+func (u *Upstream) Drain(ctx context.Context, server *UpstreamServer) error {
+    u.mux.Lock()
+    found := false
+    for _, s := range u.servers {
+        if s == server {
+            found = true
+            break
+        }
+    }
+    u.mux.Unlock()
+    if !found {
+        return fmt.Errorf("server %s is not found", server)
+    }
+
+    server.mux.Lock()
+    server.draining = true
+    server.mux.Unlock()
+
+    ticker := time.NewTicker(drainCheckInterval)
+    defer ticker.Stop()
+    for server.Connections() > 0 {
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            server.mux.Lock()
+            server.draining = false
+            server.mux.Unlock()
+            return ctx.Err()
+        }
+    }
+
+    err := u.RemoveServer(server)
+    server.mux.Lock()
+    server.draining = false
+    server.mux.Unlock()
+    return err
+}
+
+// closeServerConnections closes idle connections of server's own
+// transport.
+func closeServerConnections(server *UpstreamServer) {
+    if t := server.ownTransport(); t != nil {
+        t.CloseIdleConnections()
+    }
+}
+
+// SetClock sets source of time used to track servers errors.
+func (u *Upstream) SetClock(c Clock) *Upstream {
+    u.clock.Store(&c)
+    return u
+}
+
+// now returns current time from upstream's clock.
+func (u *Upstream) now() time.Time {
+    return (*u.clock.Load()).Now()
 }
 
-// StartTimer create ticker for each server with ErrorsTimeout and MaxErrors 
-// greater than zero.
+// StartTimers starts goroutine which returns online servers taken offline by
+// errors when their ErrorsTimeout expires. Servers with zero ErrorsTimeout or
+// MaxErrors are never taken offline. Repeated calls do nothing until
+// StopTimers.
 func (u *Upstream) StartTimers() {
-    for i := range u.servers {
-        s := u.servers[i]
-        if s.ErrorsTimeout() > 0 && s.MaxErrors() > 0 {
-            //t := time.NewTicker(time.Second * s.errorsTimeout)
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    if u.stop != nil {
+        return
+    }
+
+    stop := make(chan struct{})
+    u.stop = stop
+    u.wg.Add(1)
+    go func() {
+        defer u.wg.Done()
+        ticker := time.NewTicker(errorsCheckInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                u.checkServers()
+            case <-stop:
+                return
+            }
         }
+    }()
+}
+
+// StopTimers stops goroutine started by StartTimers and waits for it.
+func (u *Upstream) StopTimers() {
+    u.mux.Lock()
+    if u.stop == nil {
+        u.mux.Unlock()
+        return
+    }
+    close(u.stop)
+    u.stop = nil
+    u.mux.Unlock()
+
+    u.wg.Wait()
+}
+
+// checkServers checks errors of every server.
+func (u *Upstream) checkServers() {
+    now := u.now()
+    for _, s := range u.Servers() {
+        s.checkErrors(now)
     }
 }
 
+// failed registers error of request served by server.
+func (u *Upstream) failed(server *UpstreamServer) {
+    server.incrErrors(u.now())
+}
+
 // Strategy returns upstream strategy.
 func (u *Upstream) Strategy() UpstreamStrategy {
     return u.strategy
 }
 
-// Servers returns upstream servers.
+// Servers returns copy of upstream servers list.
+// Method is safe for concurrent access.
 func (u *Upstream) Servers() []*UpstreamServer {
-    ret := make([]*UpstreamServer, 0)
+    u.mux.Lock()
+    defer u.mux.Unlock()
+    ret := make([]*UpstreamServer, len(u.servers))
     copy(ret, u.servers)
     return ret
 }
 
+// transportFor returns transport speaking server's protocol. Servers with
+// own TLS options have own transports.
+func (u *Upstream) transportFor(server *UpstreamServer) http.RoundTripper {
+    if t := server.ownTransport(); t != nil {
+        return t
+    }
+    return u.transports[server.Protocol()]
+}
+
+// roundTrip sends request to server using upstream transport. Redirects are
+// never followed.
+func (u *Upstream) roundTrip(server *UpstreamServer, r *http.Request) (*http.Response, error) {
+    return u.transportFor(server).RoundTrip(r)
+}
+
+// closeIdleConnections closes idle connections to servers.
+func (u *Upstream) closeIdleConnections() {
+    for _, t := range u.transports {
+        t.CloseIdleConnections()
+    }
+    for _, s := range u.Servers() {
+        closeServerConnections(s)
+    }
+}
+
+// modifyResponse passes response of server to strategy if it's
+// ResponseStrategy.
+func (u *Upstream) modifyResponse(r *http.Request, server *UpstreamServer, res *http.Response) {
+    if rs, ok := u.strategy.(ResponseStrategy); ok {
+        rs.ModifyResponse(r, server, res)
+    }
+}
+
 // next returns server for request processing.
 func (u *Upstream) next(r *http.Request) (*UpstreamServer, error) {
     return u.strategy.Next(r)
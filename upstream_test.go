@@ -1,12 +1,17 @@
 package proxy
 
 import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync"
     "testing"
+    "time"
 )
 
 func TestUpstreamServer(t *testing.T) {
     addr := "http://127.0.0.1:8080"
-    weight := uint8(1)
+    weight := uint(1)
     server := NewUpstreamServer(addr, weight)
 
     t.Run("Weight", func (t *testing.T) {
@@ -22,3 +27,369 @@ func TestUpstreamServer(t *testing.T) {
     })
 }
 
+
+// testClock is Clock with manually controlled time.
+type testClock struct {
+    now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+    return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+    c.now = c.now.Add(d)
+}
+
+func TestUpstreamServer_ErrorsBounded(t *testing.T) {
+    clock := &testClock{now: time.Unix(1000, 0)}
+    server := NewUpstreamServer("http://127.0.0.1:8080", 1)
+    server.SetMaxErrors(3).SetErrorsTimeout(10)
+    u := NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{})
+    u.SetClock(clock)
+
+    t.Run("Offline", func (t *testing.T) {
+        for i := 0; i < 100; i++ {
+            u.failed(server)
+        }
+        if len(server.failures) > 3 {
+            t.Errorf("failures count is %d; want at most %d", len(server.failures), 3)
+        }
+        clock.Add(time.Second * 10)
+        u.checkServers()
+        if !server.Online() {
+            t.Errorf("server is offline after timeout; want online")
+        }
+    })
+
+    t.Run("Unhealthy", func (t *testing.T) {
+        // server is offline by health check, so errors don't put it offline
+        server.mux.Lock()
+        server.healthy = false
+        server.online = false
+        server.mux.Unlock()
+        for i := 0; i < 100; i++ {
+            u.failed(server)
+        }
+        if len(server.failures) > 3 {
+            t.Errorf("failures count is %d; want at most %d", len(server.failures), 3)
+        }
+    })
+}
+
+func TestUpstreamServer_Errors(t *testing.T) {
+    clock := &testClock{now: time.Unix(1000, 0)}
+    server := NewUpstreamServer("http://127.0.0.1:8080", 1)
+    server.SetMaxErrors(3).SetErrorsTimeout(10)
+    u := NewUpstream([]*UpstreamServer{server}, &StrategyRoundRobin{})
+    u.SetClock(clock)
+
+    t.Run("SlidingWindow", func (t *testing.T) {
+        u.failed(server)
+        clock.Add(time.Second * 6)
+        u.failed(server)
+        clock.Add(time.Second * 6)
+        u.failed(server)
+        u.checkServers()
+
+        if server.Errors() != 2 {
+            t.Errorf("errors is %d; want %d", server.Errors(), 2)
+        }
+        if !server.Online() {
+            t.Errorf("server is offline; want online")
+        }
+    })
+
+    t.Run("Offline", func (t *testing.T) {
+        clock.Add(time.Second)
+        u.failed(server)
+        if server.Online() {
+            t.Fatalf("server is online; want offline")
+        }
+
+        clock.Add(time.Second * 9)
+        u.checkServers()
+        if server.Online() {
+            t.Fatalf("server is online before timeout; want offline")
+        }
+
+        clock.Add(time.Second)
+        u.checkServers()
+        if !server.Online() {
+            t.Fatalf("server is offline after timeout; want online")
+        }
+        if server.Errors() != 0 {
+            t.Errorf("errors is %d; want %d", server.Errors(), 0)
+        }
+    })
+
+    t.Run("Disabled", func (t *testing.T) {
+        server.SetMaxErrors(0)
+        for i := 0; i < 5; i++ {
+            u.failed(server)
+        }
+        if !server.Online() {
+            t.Errorf("server is offline with zero MaxErrors; want online")
+        }
+
+        server.SetMaxErrors(1).SetErrorsTimeout(0)
+        u.failed(server)
+        if !server.Online() {
+            t.Errorf("server is offline with zero ErrorsTimeout; want online")
+        }
+    })
+
+    t.Run("DisableOffline", func (t *testing.T) {
+        server.SetErrorsTimeout(10)
+        u.failed(server)
+        if server.Online() {
+            t.Fatalf("server is online; want offline")
+        }
+
+        server.SetMaxErrors(0)
+        if !server.Online() {
+            t.Errorf("server is offline after disabling; want online")
+        }
+    })
+}
+
+func TestUpstream_Timers(t *testing.T) {
+    u := NewUpstream([]*UpstreamServer{
+        NewUpstreamServer("http://127.0.0.1:8080", 1),
+    }, &StrategyRoundRobin{})
+    proxy := NewProxy(u)
+
+    proxy.Start()
+    proxy.Start()
+    if !proxy.Started() {
+        t.Fatalf("proxy is not started")
+    }
+    if u.stop == nil {
+        t.Fatalf("timers are not started")
+    }
+
+    proxy.Stop()
+    proxy.Stop()
+    if proxy.Started() {
+        t.Fatalf("proxy is started after Stop")
+    }
+    if u.stop != nil {
+        t.Fatalf("timers are not stopped")
+    }
+
+    // requests in flight during shutdown don't start stopped proxy
+    proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+    if proxy.Started() || u.stop != nil {
+        t.Errorf("proxy is started by request after Stop")
+    }
+
+    proxy.Start()
+    defer proxy.Stop()
+    if !proxy.Started() {
+        t.Errorf("proxy is not started after Stop")
+    }
+}
+
+func TestProxy_LazyStart(t *testing.T) {
+    u := NewUpstream([]*UpstreamServer{
+        NewUpstreamServer("http://127.0.0.1:8080", 1),
+    }, &StrategyRoundRobin{})
+    proxy := NewProxy(u)
+    defer proxy.Stop()
+
+    proxy.GetHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+    if !proxy.Started() {
+        t.Errorf("proxy is not started by first request")
+    }
+}
+
+func TestNewUpstreamServer_Path(t *testing.T) {
+    server := NewUpstreamServer("https://127.0.0.1:8443/api/", 1)
+    if server.Path() != "/api" {
+        t.Errorf("path is '%s'; want '%s'", server.Path(), "/api")
+    }
+    if server.String() != "https://127.0.0.1:8443" {
+        t.Errorf("addr is '%s'; want '%s'", server.String(), "https://127.0.0.1:8443")
+    }
+}
+
+func TestUpstream_Servers(t *testing.T) {
+    a := NewUpstreamServer("http://127.0.0.1:8000", 1)
+    b := NewUpstreamServer("http://127.0.0.1:8001", 1)
+    c := NewUpstreamServer("http://127.0.0.1:8002", 1)
+    u := NewUpstream([]*UpstreamServer{a, b}, &StrategyRoundRobin{})
+    r, _ := http.NewRequest("GET", "http://127.0.0.1", nil)
+
+    // check compares upstream servers and servers returned by strategy with
+    // want.
+    check := func (want ...*UpstreamServer) {
+        t.Helper()
+        got := u.Servers()
+        if len(got) != len(want) {
+            t.Fatalf("servers count is %d; want %d", len(got), len(want))
+        }
+        for i := range want {
+            if got[i] != want[i] {
+                t.Errorf("%d] server is '%s'; want '%s'", i, got[i], want[i])
+            }
+        }
+        seen := make(map[*UpstreamServer]bool)
+        for range want {
+            next, err := u.next(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            seen[next] = true
+        }
+        for _, s := range want {
+            if !seen[s] {
+                t.Errorf("server '%s' is not returned by strategy", s)
+            }
+        }
+    }
+
+    check(a, b)
+    u.Servers()[0] = c
+    check(a, b)
+
+    if err := u.AddServer(c); err != nil {
+        t.Fatal(err)
+    }
+    check(a, b, c)
+    if err := u.AddServer(c); err == nil {
+        t.Errorf("server is added twice")
+    }
+
+    if err := u.RemoveServer(a); err != nil {
+        t.Fatal(err)
+    }
+    check(b, c)
+    if err := u.RemoveServer(a); err == nil {
+        t.Errorf("missing server is removed")
+    }
+
+    if err := u.ReplaceServers([]*UpstreamServer{c, a}); err != nil {
+        t.Fatal(err)
+    }
+    check(c, a)
+    invalid := NewUpstreamServer("http://127.0.0.1:8003", 1).SetProtocol(ProtocolHTTP2)
+    if err := u.ReplaceServers([]*UpstreamServer{a, invalid}); err == nil {
+        t.Errorf("invalid server is accepted")
+    }
+    check(c, a)
+
+    t.Run("Concurrent", func (t *testing.T) {
+        var wg sync.WaitGroup
+        for i := 0; i < 4; i++ {
+            wg.Add(2)
+            go func() {
+                defer wg.Done()
+                for j := 0; j < 100; j++ {
+                    u.AddServer(b)
+                    u.RemoveServer(b)
+                }
+            }()
+            go func() {
+                defer wg.Done()
+                for j := 0; j < 100; j++ {
+                    if _, err := u.next(r); err != nil {
+                        t.Error(err)
+                        return
+                    }
+                }
+            }()
+        }
+        wg.Wait()
+    })
+}
+
+func TestUpstreamServer_acquire(t *testing.T) {
+    server := NewUpstreamServer("http://127.0.0.1:8080", 1)
+    if !server.acquire() || server.Connections() != 1 {
+        t.Fatalf("connection isn't acquired")
+    }
+
+    server.mux.Lock()
+    server.draining = true
+    server.mux.Unlock()
+    if server.acquire() {
+        t.Errorf("connection to draining server is acquired")
+    }
+    if server.Connections() != 1 {
+        t.Errorf("connections is %d; want %d", server.Connections(), 1)
+    }
+}
+
+func TestUpstream_Drain(t *testing.T) {
+    release := make(chan struct{})
+    started := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            close(started)
+            <-release
+        }
+    }))
+    defer backend.Close()
+    other := httptest.NewServer(http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusAccepted)
+    }))
+    defer other.Close()
+
+    drained := NewUpstreamServer(backend.URL, 1)
+    u := NewUpstream([]*UpstreamServer{drained, NewUpstreamServer(other.URL, 1)}, &StrategyRoundRobin{})
+    proxy := NewProxy(u)
+    defer proxy.Stop()
+    front := httptest.NewServer(proxy.GetHandler())
+    defer front.Close()
+
+    slow := make(chan int)
+    go func() {
+        res, err := http.Get(front.URL + "/slow")
+        if err != nil {
+            slow <- 0
+            return
+        }
+        res.Body.Close()
+        slow <- res.StatusCode
+    }()
+    <-started
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 100)
+    defer cancel()
+    if err := u.Drain(ctx, drained); err != context.DeadlineExceeded {
+        t.Fatalf("drain error is '%v'; want '%v'", err, context.DeadlineExceeded)
+    }
+    if drained.Draining() {
+        t.Fatalf("server is draining after drain timeout")
+    }
+
+    done := make(chan error)
+    go func() {
+        done <- u.Drain(context.Background(), drained)
+    }()
+    for !drained.Draining() {
+        time.Sleep(time.Millisecond)
+    }
+
+    for i := 0; i < 3; i++ {
+        res, err := http.Get(front.URL)
+        if err != nil {
+            t.Fatal(err)
+        }
+        res.Body.Close()
+        if res.StatusCode != http.StatusAccepted {
+            t.Errorf("status is %d; want %d", res.StatusCode, http.StatusAccepted)
+        }
+    }
+
+    close(release)
+    if status := <-slow; status != http.StatusOK {
+        t.Errorf("in-flight request status is %d; want %d", status, http.StatusOK)
+    }
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    if servers := u.Servers(); len(servers) != 1 || servers[0] == drained {
+        t.Errorf("drained server is not removed")
+    }
+}